@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ichiban/btdb"
+)
+
+// check prints the problems found in the file and returns the exit status. the file is opened read-only.
+func check(args []string) int {
+	if len(args) != 1 {
+		log.Printf("usage: btdb check <file>")
+		return 2
+	}
+
+	db, err := btdb.Open(args[0], btdb.ReadOnly(true))
+	if err != nil {
+		log.Printf("failed to open file: %v", err)
+		return 1
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	r, err := db.Check()
+	if err != nil {
+		log.Printf("failed to check: %v", err)
+		return 1
+	}
+	for _, p := range r.Problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d pages, %d trees, %d problems\n", r.Pages, len(r.Trees), len(r.Problems))
+	if !r.OK() {
+		return 1
+	}
+	return 0
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"database/sql/driver"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -20,15 +21,34 @@ import (
 	"github.com/ichiban/linesqueak"
 )
 
+var readOnly = flag.Bool("readonly", false, "open the file read-only")
+
 func main() {
-	filename := os.Args[1]
-	db, err := btdb.Open(filename)
-	if err != nil {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		log.Printf("usage: btdb [-readonly] <file>")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "check":
+		os.Exit(check(args[1:]))
+	case "migrate":
+		os.Exit(migrate(args[1:]))
+	case "stats":
+		os.Exit(stats(args[1:]))
+	case "vacuum":
+		os.Exit(vacuum(args[1:]))
+	}
+
+	filename := args[0]
+	db, err := btdb.Open(filename, btdb.ReadOnly(*readOnly))
+	if os.IsNotExist(err) && !*readOnly {
 		db, err = btdb.Create(filename)
-		if err != nil {
-			log.Printf("failed to open file: %v", err)
-			return
-		}
+	}
+	if err != nil {
+		log.Printf("failed to open file: %v", err)
+		return
 	}
 	defer func() {
 		_ = db.Close()
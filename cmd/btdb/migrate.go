@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+
+	"github.com/ichiban/btdb"
+)
+
+// migrate copies the file into a new file in the current file format and returns the exit status.
+func migrate(args []string) int {
+	if len(args) != 2 {
+		log.Printf("usage: btdb migrate <src> <dst>")
+		return 2
+	}
+
+	if err := btdb.Migrate(args[0], args[1]); err != nil {
+		log.Printf("failed to migrate: %v", err)
+		return 1
+	}
+	return 0
+}
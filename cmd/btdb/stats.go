@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ichiban/btdb"
+	"github.com/ichiban/btdb/store"
+)
+
+// stats prints the shape of the catalog and the trees registered in it and returns the exit status. the file is opened
+// read-only.
+func stats(args []string) int {
+	if len(args) != 1 {
+		log.Printf("usage: btdb stats <file>")
+		return 2
+	}
+
+	db, err := btdb.Open(args[0], btdb.ReadOnly(true))
+	if err != nil {
+		log.Printf("failed to open file: %v", err)
+		return 1
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	s, err := db.Stats()
+	if err != nil {
+		log.Printf("failed to get stats: %v", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "tree\tdepth\tbranches\tleaves\toverflows\tcells\tleaf fill\tbytes\t")
+	row := func(name string, t *store.Stats) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.1f%%\t%d\t\n", name, t.Depth, t.BranchPages, t.LeafPages, t.OverflowPages, t.Cells, 100*t.LeafFill, t.Bytes)
+	}
+	if s.Catalog != nil {
+		row("catalog", s.Catalog)
+	}
+	for _, t := range s.Trees {
+		name := make([]string, len(t.Key))
+		for i, k := range t.Key {
+			name[i] = fmt.Sprint(k)
+		}
+		row(strings.Join(name, " "), t.Stats)
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("failed to print stats: %v", err)
+		return 1
+	}
+	fmt.Printf("%d pages, %d free pages\n", s.Pages, s.FreePages)
+	return 0
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ichiban/btdb"
+)
+
+// vacuum rewrites the file densely packed and returns the exit status.
+func vacuum(args []string) int {
+	if len(args) != 1 {
+		log.Printf("usage: btdb vacuum <file>")
+		return 2
+	}
+
+	before, err := os.Stat(args[0])
+	if err != nil {
+		log.Printf("failed to stat file: %v", err)
+		return 1
+	}
+
+	db, err := btdb.Open(args[0], btdb.ReadOnly(*readOnly))
+	if err != nil {
+		log.Printf("failed to open file: %v", err)
+		return 1
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if err := db.Vacuum(); err != nil {
+		log.Printf("failed to vacuum: %v", err)
+		return 1
+	}
+
+	after, err := os.Stat(args[0])
+	if err != nil {
+		log.Printf("failed to stat file: %v", err)
+		return 1
+	}
+	fmt.Printf("%d bytes -> %d bytes\n", before.Size(), after.Size())
+	return 0
+}
@@ -15,8 +15,20 @@ type Database struct {
 	tree *store.BTree
 }
 
+// Memory is the name of a database which lives in memory until it's closed.
+const Memory = ":memory:"
+
+// Create creates a new database file. if the name is Memory, it creates a database in memory instead.
 func Create(name string) (*Database, error) {
-	t, err := store.Create(name, store.PageSize(4*1024), store.CellSize(512))
+	var (
+		t   *store.BTree
+		err error
+	)
+	if name == Memory {
+		t, err = store.CreateStorage(&store.Memory{}, store.PageSize(4*1024), store.CellSize(512))
+	} else {
+		t, err = store.Create(name, store.PageSize(4*1024), store.CellSize(512))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -32,8 +44,34 @@ func Create(name string) (*Database, error) {
 	}, nil
 }
 
-func Open(name string) (*Database, error) {
-	t, err := store.Open(name)
+// Option changes how Open opens a database.
+type Option func(*options)
+
+type options struct {
+	readOnly bool
+}
+
+// ReadOnly sets whether the database is opened only for reading. see store.ReadOnly.
+func ReadOnly(enabled bool) Option {
+	return func(o *options) {
+		o.readOnly = enabled
+	}
+}
+
+// Open opens the database file. if the name is Memory, it creates a new database in memory since nothing is left in
+// memory after closing one.
+func Open(name string, opts ...Option) (*Database, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if name == Memory {
+		if o.readOnly {
+			return nil, xerrors.Errorf("can't open %s read only", Memory)
+		}
+		return Create(name)
+	}
+	t, err := store.Open(name, store.ReadOnly(o.readOnly))
 	if err != nil {
 		return nil, err
 	}
@@ -42,10 +80,30 @@ func Open(name string) (*Database, error) {
 	}, nil
 }
 
+// Migrate copies the file src into a new file dst in the current file format. see store.Migrate.
+func Migrate(src, dst string) error {
+	return store.Migrate(src, dst)
+}
+
 func (d *Database) Close() error {
 	return d.tree.Close()
 }
 
+// Check validates the file. see store.BTree.Check.
+func (d *Database) Check() (*store.Report, error) {
+	return d.tree.Check()
+}
+
+// Stats returns the statistics of the file. see store.BTree.Summary.
+func (d *Database) Stats() (*store.Summary, error) {
+	return d.tree.Summary()
+}
+
+// Vacuum rewrites the file densely packed. see store.BTree.Vacuum.
+func (d *Database) Vacuum() error {
+	return d.tree.Vacuum()
+}
+
 func (d *Database) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
 	p := sql.NewParser(d.tree, query)
 	s, err := p.DirectSQLStatement()
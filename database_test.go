@@ -0,0 +1,133 @@
+package btdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
+
+	"github.com/ichiban/btdb/sql"
+	"github.com/ichiban/btdb/store"
+)
+
+func TestOpen_Memory(t *testing.T) {
+	assert := assert.New(t)
+
+	query := func(db *Database, q string) [][]driver.Value {
+		rows, err := db.QueryContext(context.Background(), q, nil)
+		assert.NoError(err)
+		var ret [][]driver.Value
+		for {
+			row := make([]driver.Value, len(rows.Columns()))
+			if err := rows.Next(row); err != nil {
+				assert.Equal(io.EOF, err)
+				return ret
+			}
+			ret = append(ret, row)
+		}
+	}
+
+	db, err := Open(Memory)
+	assert.NoError(err)
+	query(db, "create table dept (deptno integer, dname text, primary key (deptno));")
+	query(db, "insert into dept (deptno, dname) values (10, 'ACCOUNTING'), (20, 'MARKETING');")
+	assert.Len(query(db, "select * from dept;"), 2)
+	query(db, "vacuum;")
+	assert.Len(query(db, "select * from dept;"), 2)
+	assert.NoError(db.Close())
+
+	// every database in memory is a new one.
+	db, err = Open(Memory)
+	assert.NoError(err)
+	defer func() { assert.NoError(db.Close()) }()
+	_, err = db.QueryContext(context.Background(), "select * from dept;", nil)
+	assert.Error(err)
+}
+
+func TestOpen_ReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	// query runs the statement to the end and returns the number of rows.
+	query := func(db *Database, q string) (int, error) {
+		rows, err := db.QueryContext(context.Background(), q, nil)
+		if err != nil {
+			return 0, err
+		}
+		row := make([]driver.Value, len(rows.Columns()))
+		for n := 0; ; n++ {
+			if err := rows.Next(row); err != nil {
+				if err == io.EOF {
+					return n, nil
+				}
+				return n, err
+			}
+		}
+	}
+
+	name := filepath.Join(dir, "test.db")
+	db, err := Create(name)
+	assert.NoError(err)
+	_, err = query(db, "create table dept (deptno integer, dname text, primary key (deptno));")
+	assert.NoError(err)
+	_, err = query(db, "insert into dept (deptno, dname) values (10, 'ACCOUNTING');")
+	assert.NoError(err)
+	assert.NoError(db.Close())
+
+	db, err = Open(name, ReadOnly(true))
+	assert.NoError(err)
+	defer func() { assert.NoError(db.Close()) }()
+	n, err := query(db, "select * from dept;")
+	assert.NoError(err)
+	assert.Equal(1, n)
+	_, err = query(db, "insert into dept (deptno, dname) values (20, 'MARKETING');")
+	assert.True(xerrors.Is(err, store.ErrReadOnly), "%v", err)
+
+	_, err = Open(Memory, ReadOnly(true))
+	assert.Error(err)
+}
+
+func TestInsert_Exec(t *testing.T) {
+	assert := assert.New(t)
+
+	db, err := Open(Memory)
+	assert.NoError(err)
+	defer func() { assert.NoError(db.Close()) }()
+
+	exec := func(q string) driver.Result {
+		s, err := sql.NewParser(db.tree, q).DirectSQLStatement()
+		assert.NoError(err)
+		r, err := s.(driver.StmtExecContext).ExecContext(context.Background(), nil)
+		assert.NoError(err)
+		return r
+	}
+
+	exec("create table dept (deptno integer, dname text, primary key (deptno));")
+
+	// the rows are committed even if nobody reads the result.
+	exec("insert into dept (deptno, dname) values (10, 'ACCOUNTING'), (20, 'MARKETING');")
+	exec("create table emp (empno integer, ename text, primary key (empno));")
+
+	r := exec("insert into dept (deptno, dname) values (30, 'SALES');")
+	n, err := r.RowsAffected()
+	assert.NoError(err)
+	assert.Equal(int64(1), n)
+
+	rows, err := db.QueryContext(context.Background(), "select * from dept;", nil)
+	assert.NoError(err)
+	row := make([]driver.Value, len(rows.Columns()))
+	for _, k := range []int64{10, 20, 30} {
+		assert.NoError(rows.Next(row))
+		assert.EqualValues(k, row[0])
+	}
+	assert.Equal(io.EOF, rows.Next(row))
+}
@@ -3,6 +3,7 @@ package sql
 import (
 	"context"
 	"database/sql/driver"
+	"io"
 
 	"github.com/ichiban/btdb/store"
 )
@@ -31,9 +32,14 @@ func (i *InsertStatement) Query(args []driver.Value) (driver.Rows, error) {
 
 func (i *InsertStatement) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
 	r, err := i.QueryContext(ctx, args)
-	return r.(driver.Result), err
+	if err != nil {
+		return nil, err
+	}
+	return r.(driver.Result), nil
 }
 
+// QueryContext inserts the rows and commits them before it returns the inserted rows so that the transaction never
+// waits for the caller to read them.
 func (i *InsertStatement) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
 	tk := []interface{}{"table", i.Target}
 
@@ -50,51 +56,87 @@ func (i *InsertStatement) QueryContext(ctx context.Context, args []driver.NamedV
 
 	cols := td.columnNames()
 
-	ch := make(chan []driver.Value)
-	rows := Rows{
+	// the source is read before the transaction begins so that the transaction doesn't wait for it either.
+	var vals [][]driver.Value
+	src := i.Source.projection(cols)
+	for {
+		val := make([]driver.Value, len(td.Columns))
+		if err := src.Next(val); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+
+	tx, err := i.store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := i.insert(ctx, tx, td, vals); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []driver.Value, len(vals))
+	for _, val := range vals {
+		ch <- val
+	}
+	close(ch)
+
+	return &Rows{
 		cols: cols,
 		rows: ch,
+	}, nil
+}
+
+// insert inserts the rows into the table in the transaction and updates the root of the table in the catalog.
+func (i *InsertStatement) insert(ctx context.Context, tx *store.Tx, td *TableDefinition, vals [][]driver.Value) error {
+	tk := []interface{}{"table", i.Target}
+
+	vs, err := tx.Search(tx.Root(), tk)
+	if err != nil {
+		return err
 	}
 
-	go func() {
-		src := i.Source.projection(cols)
+	for _, val := range vals {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		for {
-			val := make([]driver.Value, len(td.Columns))
-			if err := src.Next(val); err != nil {
-				rows.Err = err
-				break
-			}
-			k := make([]interface{}, 0, len(td.PrimaryKey))
-			v := make([]interface{}, 0, len(td.Columns)-len(td.PrimaryKey))
-
-			for i, c := range td.Columns {
-				if td.primaryKey(c.Name) {
-					k = append(k, val[i])
-				} else {
-					v = append(v, val[i])
-				}
-			}
+		k := make([]interface{}, 0, len(td.PrimaryKey))
+		v := make([]interface{}, 0, len(td.Columns)-len(td.PrimaryKey))
 
-			or := vs[0].(uint64)
-			nr, err := i.store.Insert(int(or), k, v)
-			if err != nil {
-				rows.Err = err
-				break
+		for i, c := range td.Columns {
+			if td.primaryKey(c.Name) {
+				k = append(k, val[i])
+			} else {
+				v = append(v, val[i])
 			}
+		}
 
-			ch <- val
+		or := vs[0].(uint64)
+		nr, err := tx.Insert(int(or), k, v)
+		if err != nil {
+			return err
+		}
 
-			if nr != int(or) {
-				vs[0] = nr
-				if err := i.store.Update(i.store.Root(), tk, vs); err != nil {
-					rows.Err = err
-					break
+		if nr != int(or) {
+			vs = append([]interface{}{uint64(nr)}, vs[1:]...)
+			cr, err := tx.Update(tx.Root(), tk, vs)
+			if err != nil {
+				return err
+			}
+			if cr != tx.Root() {
+				if err := tx.UpdateRoot(cr); err != nil {
+					return err
 				}
 			}
 		}
-		close(ch)
-	}()
-
-	return &rows, nil
+	}
+	return nil
 }
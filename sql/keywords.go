@@ -310,6 +310,7 @@ var keywords = map[string]tokenType{
 	"UPPER":                            kwUpper,
 	"USER":                             kwUser,
 	"USING":                            kwUsing,
+	"VACUUM":                           kwVacuum,
 	"VALUE":                            kwValue,
 	"VALUES":                           kwValues,
 	"VALUE_OF":                         kwValueOf,
@@ -393,6 +393,7 @@ const (
 	kwUpper
 	kwUser
 	kwUsing
+	kwVacuum
 	kwValue
 	kwValues
 	kwValueOf
@@ -1049,6 +1050,8 @@ func (t tokenType) String() string {
 		return "USER"
 	case kwUsing:
 		return "USING"
+	case kwVacuum:
+		return "VACUUM"
 	case kwValue:
 		return "VALUE"
 	case kwValues:
@@ -59,6 +59,8 @@ func (p *Parser) directlyExecutableStatement() (driver.Stmt, error) {
 		return p.directSQLDataStatement()
 	case kwCreate:
 		return p.sqlSchemaStatement()
+	case kwVacuum:
+		return p.vacuumStatement()
 	default:
 		return nil, xerrors.New("neither direct SQL data statement nor SQL schema statement")
 	}
@@ -436,6 +438,14 @@ func (p *Parser) fromDefault() (*Rows, error) {
 	return nil, nil
 }
 
+// vacuumStatement is not in the standard. it compacts the database file.
+func (p *Parser) vacuumStatement() (driver.Stmt, error) {
+	if _, err := p.accept(kwVacuum); err != nil {
+		return nil, err
+	}
+	return &VacuumStatement{store: p.store}, nil
+}
+
 func (p *Parser) sqlSchemaStatement() (driver.Stmt, error) {
 	return p.sqlSchemaDefinitionStatement()
 }
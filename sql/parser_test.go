@@ -79,4 +79,15 @@ SELECT * FROM dept;
 		ss := s.(*SelectStatement)
 		assert.Equal("dept", ss.From)
 	})
+
+	t.Run("vacuum", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+		p := NewParser(nil, `
+VACUUM;
+`)
+		s, err := p.DirectSQLStatement()
+		assert.NoError(err)
+		require.IsType(&VacuumStatement{}, s)
+	})
 }
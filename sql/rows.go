@@ -23,11 +23,12 @@ func (Rows) Close() error {
 }
 
 func (r *Rows) Next(dest []driver.Value) error {
-	if err := r.Err; err != nil {
-		return err
-	}
 	row, ok := <-r.rows
 	if !ok {
+		// Err is set before rows is closed.
+		if err := r.Err; err != nil {
+			return err
+		}
 		return io.EOF
 	}
 	for i, v := range row {
@@ -42,14 +43,16 @@ func (Rows) LastInsertId() (int64, error) {
 
 func (r *Rows) RowsAffected() (int64, error) {
 	c := int64(0)
-	var vs []driver.Value
+	vs := make([]driver.Value, len(r.cols))
 	for {
 		if err := r.Next(vs); err != nil {
+			if err == io.EOF {
+				return c, nil
+			}
 			return 0, err
 		}
 		c++
 	}
-	return c, nil
 }
 
 func (r *Rows) projection(cols []string) *Rows {
@@ -3,6 +3,7 @@ package sql
 import (
 	"context"
 	"database/sql/driver"
+	"io"
 
 	"golang.org/x/xerrors"
 
@@ -68,7 +69,7 @@ func (q *SelectStatement) QueryContext(ctx context.Context, args []driver.NamedV
 	go func() {
 		for {
 			if err := iter.Next(); err != nil {
-				if err == store.ErrNotFound {
+				if err == io.EOF {
 					break
 				}
 				rows.Err = xerrors.Errorf("failed iterate: %w", err)
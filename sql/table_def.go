@@ -40,12 +40,16 @@ func (t *TableDefinition) ExecContext(ctx context.Context, args []driver.NamedVa
 }
 
 func (t *TableDefinition) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
-	n, err := t.store.CreateRoot()
+	tx, err := t.store.Begin()
 	if err != nil {
 		return nil, err
 	}
-	r, err := t.store.Insert(t.store.Root(), []interface{}{"table", t.Name}, []interface{}{n, t.RawSQL})
+	n, err := t.create(tx)
 	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
@@ -55,19 +59,29 @@ func (t *TableDefinition) QueryContext(ctx context.Context, args []driver.NamedV
 		close(ch)
 	}()
 
-	rows := Rows{
+	return &Rows{
 		cols: []string{"kind", "name", "root", "body"},
 		rows: ch,
-	}
+	}, nil
+}
 
-	if t.store.Root() == r {
-		return &rows, nil
+// create creates the root of the table and registers it to the catalog.
+func (t *TableDefinition) create(tx *store.Tx) (int, error) {
+	n, err := tx.CreateRoot()
+	if err != nil {
+		return 0, err
 	}
-	if err := t.store.UpdateRoot(r); err != nil {
-		return nil, err
+	r, err := tx.Insert(tx.Root(), []interface{}{"table", t.Name}, []interface{}{n, t.RawSQL})
+	if err != nil {
+		return 0, err
 	}
-
-	return &rows, nil
+	if tx.Root() == r {
+		return n, nil
+	}
+	if err := tx.UpdateRoot(r); err != nil {
+		return 0, err
+	}
+	return n, nil
 }
 
 func (t *TableDefinition) columnNames() []string {
@@ -0,0 +1,49 @@
+package sql
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/ichiban/btdb/store"
+)
+
+// VacuumStatement rewrites the database file densely packed.
+type VacuumStatement struct {
+	store *store.BTree
+}
+
+func (v *VacuumStatement) Close() error {
+	return nil
+}
+
+func (v *VacuumStatement) NumInput() int {
+	return 0
+}
+
+func (v *VacuumStatement) Exec(args []driver.Value) (driver.Result, error) {
+	return v.ExecContext(context.Background(), namedValues(args))
+}
+
+func (v *VacuumStatement) Query(args []driver.Value) (driver.Rows, error) {
+	return v.QueryContext(context.Background(), namedValues(args))
+}
+
+func (v *VacuumStatement) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	r, err := v.QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return r.(driver.Result), nil
+}
+
+func (v *VacuumStatement) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := v.store.Vacuum(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []driver.Value)
+	close(ch)
+	return &Rows{
+		rows: ch,
+	}, nil
+}
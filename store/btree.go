@@ -5,19 +5,82 @@ import (
 	"encoding/binary"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
-	"sort"
+	"sync"
+	"time"
 
 	"golang.org/x/xerrors"
 )
 
 var ErrNotFound = xerrors.New("not found")
 
+var (
+	ErrInTransaction = xerrors.New("in transaction")
+	ErrNoTransaction = xerrors.New("no transaction")
+)
+
+// ErrReadOnly is returned when a file opened with ReadOnly is about to be changed.
+var ErrReadOnly = xerrors.New("read only")
+
+// ErrLocked is returned when another process holds a lock of the file. see LockTimeout.
+var ErrLocked = xerrors.New("locked by another process")
+
+// ErrBroken is returned once a commit failed after it had changed the file so that the tree can't be rolled back. the
+// file has to be opened again.
+var ErrBroken = xerrors.New("broken by a failed commit")
+
 var errWrongSize = xerrors.New("wrong size")
 
+// errReplaced is returned when the file is replaced by Vacuum while waiting for its lock. Open and Create try again.
+var errReplaced = xerrors.New("replaced while waiting for lock")
+
+// BTree is safe for concurrent use. readers share a lock while writers hold it exclusively.
 type BTree struct {
+	mu     sync.RWMutex
+	writer sync.Mutex // writers take it before mu. BulkLoad keeps it while it lets readers in.
 	header
-	file io.ReadWriteSeeker
+	file      Storage
+	wal       *wal
+	cache     *cache
+	pages     pageNo // number of pages including the ones not written yet
+	committed header // header as of the last commit
+	tx        *snapshot
+	broken    bool // see ErrBroken
+
+	// generation is incremented whenever the pages are renumbered so that the iterators over the old pages stop.
+	generation int
+
+	mmap        bool
+	syncPolicy  SyncPolicy
+	lockTimeout time.Duration
+	readOnly    bool
+}
+
+// Storage is where pages are stored at the offsets of their page numbers. Size returns the number of bytes in it.
+// it's synced by Sync and closed by Close if it has them.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+	Size() (int64, error)
+}
+
+// fileStorage is a file as a Storage.
+type fileStorage struct {
+	*os.File
+}
+
+func (f *fileStorage) Size() (int64, error) {
+	return fileSize(f.File)
+}
+
+// fileSize returns the size of the file.
+func fileSize(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to stat: %w", err)
+	}
+	return fi.Size(), nil
 }
 
 // follows PNG file signature http://www.libpng.org/pub/png/spec/1.2/PNG-Rationale.html#R.PNG-file-signature
@@ -32,19 +95,29 @@ var validSignature = [8]byte{
 	byte('\n'), // LF
 }
 
-const headerSize = 8 + 4 + 4 + 4
+const headerSize = 8 + 4 + 4 + 4 + 4 + 4 + 4 + 4 + 4
 
 var defaultHeader = header{
 	Signature: validSignature,
 	PageSize:  4096,
 	CellSize:  256,
+	Format:    slottedCells,
+	Keys:      compressedKeys,
+	Checksums: 1,
 }
 
+const defaultCacheSize = 256
+
 type header struct {
-	Signature  [8]byte
-	PageSize   uint32
-	CellSize   uint32
-	RootPageNo pageNo
+	Signature     [8]byte
+	PageSize      uint32
+	CellSize      uint32
+	RootPageNo    pageNo
+	FreePageNo    pageNo // head of the list of free pages
+	FreePageCount uint32
+	Format        format    // files created before slotted pages have zero here which means fixed cells.
+	Keys          keyFormat // files created before ordered keys have zero here which means CBOR keys. see Migrate.
+	Checksums     uint32    // files created before page checksums have zero here which means pages aren't verified.
 }
 
 func (h *header) Root() int {
@@ -81,242 +154,1153 @@ func (h *header) WriteTo(w io.Writer) (int64, error) {
 	return int64(h.PageSize), nil
 }
 
-func Create(name string, opts ...createOption) (*BTree, error) {
-	f, err := os.Create(name)
+// Create creates a new file. an existing file is truncated once its lock is taken.
+func Create(name string, opts ...option) (*BTree, error) {
+	if readOnly(opts) {
+		return nil, ErrReadOnly
+	}
+	for {
+		b, err := createFile(name, opts)
+		if xerrors.Is(err, errReplaced) {
+			continue
+		}
+		return b, err
+	}
+}
+
+func createFile(name string, opts []option) (*BTree, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		return nil, err
 	}
+	b, err := create(&fileStorage{File: f}, name+walSuffix, opts)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// CreateStorage creates a new file in the storage s such as &Memory{}. unlike Create, it has no write-ahead log so a
+// crash while committing may leave s inconsistent.
+func CreateStorage(s Storage, opts ...option) (*BTree, error) {
+	return create(s, "", opts)
+}
+
+// create creates a new file in s with the write-ahead log of the name unless it's empty.
+func create(s Storage, walName string, opts []option) (_ *BTree, err error) {
 	b := BTree{
 		header: defaultHeader,
-		file:   f,
+		file:   s,
+		pages:  1,
 	}
-	for _, o := range opts {
-		o(&b)
+	defer b.closeWAL(&err)
+	if err := b.init(walName, opts); err != nil {
+		return nil, err
+	}
+	if b.readOnly {
+		return nil, ErrReadOnly
+	}
+	if t, ok := s.(interface{ Truncate(int64) error }); ok {
+		if err := t.Truncate(0); err != nil {
+			return nil, xerrors.Errorf("failed to truncate: %w", err)
+		}
+	}
+	if b.wal != nil {
+		if err := b.wal.reset(); err != nil {
+			return nil, xerrors.Errorf("failed to reset log: %w", err)
+		}
+	}
+	if err := b.mapFile(); err != nil {
+		return nil, err
+	}
+	if b.Format == slottedCells && 2*(slotSize+int(b.CellSize))+prefixHeaderSize > int(b.PageSize)-pageHeaderSize {
+		return nil, xerrors.Errorf("cell size too large for page size: %d", b.CellSize)
 	}
 	if err := b.updateHeader(); err != nil {
 		return nil, err
 	}
+	if err := b.syncOnCommit(); err != nil {
+		return nil, err
+	}
 	return &b, nil
 }
 
-type createOption func(*BTree)
+type option func(*BTree)
 
-func PageSize(size uint32) createOption {
+// PageSize sets the size of pages of a new file.
+func PageSize(size uint32) option {
 	return func(b *BTree) {
 		b.PageSize = size
 	}
 }
 
-func CellSize(size uint32) createOption {
+// CellSize sets the size of cells of a new file.
+func CellSize(size uint32) option {
 	return func(b *BTree) {
 		b.CellSize = size
 	}
 }
 
-func Open(name string) (*BTree, error) {
-	f, err := os.OpenFile(name, os.O_RDWR, 0666)
+// pageFormat sets the layout of pages of a new file.
+func pageFormat(f format) option {
+	return func(b *BTree) {
+		b.Format = f
+	}
+}
+
+// keyEncoding sets the format of keys of a new file.
+func keyEncoding(f keyFormat) option {
+	return func(b *BTree) {
+		b.Keys = f
+	}
+}
+
+// CacheSize sets the number of clean pages kept in memory. dirty pages are kept until they're written regardless.
+func CacheSize(n int) option {
+	return func(b *BTree) {
+		b.cache = newCache(n)
+	}
+}
+
+// MemoryMap sets whether reads of a file are served from a memory mapping of it instead of copying pages out of it.
+// writes go to the file either way. it's only supported on Linux and ignored elsewhere or for other storages.
+func MemoryMap(enabled bool) option {
+	return func(b *BTree) {
+		b.mmap = enabled
+	}
+}
+
+// SyncPolicy is when writes are synced to the disk.
+type SyncPolicy int
+
+const (
+	// SyncNone never syncs. a crash may lose or break the changes which the OS hasn't written yet.
+	SyncNone SyncPolicy = iota
+	// SyncOnCommit makes a commit durable before it returns. a file with a write-ahead log only syncs the log on
+	// commit and the file at checkpoints. other storages sync the pages and then the header.
+	SyncOnCommit
+	// SyncAlways syncs the pages and then the header of the file on every commit in addition to the log so that the
+	// file is consistent on its own.
+	SyncAlways
+)
+
+// Durability sets when writes are synced to the disk. it's SyncOnCommit by default.
+func Durability(p SyncPolicy) option {
+	return func(b *BTree) {
+		b.syncPolicy = p
+	}
+}
+
+// LockTimeout sets how long Create and Open wait for another process to release the lock of the file before they
+// return ErrLocked. they don't wait by default.
+func LockTimeout(d time.Duration) option {
+	return func(b *BTree) {
+		b.lockTimeout = d
+	}
+}
+
+// ReadOnly sets whether the file is opened only for reading. the operations which change the file return ErrReadOnly.
+// the file is locked shared with other readers instead of exclusively and the write-ahead log is left as it is.
+func ReadOnly(enabled bool) option {
+	return func(b *BTree) {
+		b.readOnly = enabled
+	}
+}
+
+// readOnly tells if the options include ReadOnly before they're applied to the tree.
+func readOnly(opts []option) bool {
+	var b BTree
+	for _, o := range opts {
+		o(&b)
+	}
+	return b.readOnly
+}
+
+// Open opens the file and replays the committed pages in its write-ahead log if any.
+func Open(name string, opts ...option) (*BTree, error) {
+	for {
+		b, err := openFile(name, opts)
+		if xerrors.Is(err, errReplaced) {
+			continue
+		}
+		return b, err
+	}
+}
+
+func openFile(name string, opts []option) (*BTree, error) {
+	flag := os.O_RDWR
+	if readOnly(opts) {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(name, flag, 0666)
 	if err != nil {
 		return nil, err
 	}
 	var h header
 	if _, err := h.ReadFrom(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	b, err := open(&fileStorage{File: f}, h, name+walSuffix, opts)
+	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
+	return b, nil
+}
+
+// OpenStorage opens the file in the storage s created by CreateStorage.
+func OpenStorage(s Storage, opts ...option) (*BTree, error) {
+	var h header
+	if _, err := h.ReadFrom(io.NewSectionReader(s, 0, math.MaxInt64)); err != nil {
+		return nil, err
+	}
+	return open(s, h, "", opts)
+}
+
+// open opens the file in s with the write-ahead log of the name unless it's empty.
+func open(s Storage, h header, walName string, opts []option) (_ *BTree, err error) {
 	b := BTree{
 		header: h,
-		file:   f,
+		file:   s,
+	}
+	defer b.closeWAL(&err)
+	if err := b.init(walName, opts); err != nil {
+		return nil, err
+	}
+	if err := b.mapFile(); err != nil {
+		return nil, err
+	}
+	if err := b.recover(); err != nil {
+		return nil, xerrors.Errorf("failed to recover: %w", err)
 	}
 	return &b, nil
 }
 
-func (b *BTree) Close() error {
-	if f, ok := b.file.(io.Closer); ok {
-		return f.Close()
+// init applies the options and takes the lock of the file. the write-ahead log is opened after the lock so that it's
+// never touched by a process without the lock.
+func (b *BTree) init(walName string, opts []option) error {
+	b.cache = newCache(defaultCacheSize)
+	b.syncPolicy = SyncOnCommit
+	for _, o := range opts {
+		o(b)
 	}
+	if f, ok := b.file.(*fileStorage); ok {
+		if err := lockFile(f.File, !b.readOnly, b.lockTimeout); err != nil {
+			return xerrors.Errorf("failed to lock %s: %w", f.Name(), err)
+		}
+		if err := checkReplaced(f.File); err != nil {
+			return err
+		}
+	}
+	if walName == "" {
+		return nil
+	}
+	if b.readOnly {
+		return b.replayWAL(walName)
+	}
+	w, err := openWAL(walName)
+	if err != nil {
+		return xerrors.Errorf("failed to open log: %w", err)
+	}
+	w.noSync = b.syncPolicy == SyncNone
+	b.wal = w
 	return nil
 }
 
-func (b *BTree) UpdateRoot(r int) error {
-	b.RootPageNo = pageNo(r)
-	return b.updateHeader()
+// checkReplaced returns errReplaced if the name of f points to another file. the lock of f means nothing then.
+func checkReplaced(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return xerrors.Errorf("failed to stat %s: %w", f.Name(), err)
+	}
+	ni, err := os.Stat(f.Name())
+	if os.IsNotExist(err) {
+		return errReplaced
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to stat %s: %w", f.Name(), err)
+	}
+	if !os.SameFile(fi, ni) {
+		return errReplaced
+	}
+	return nil
 }
 
-func (b *BTree) updateHeader() error {
-	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
-		return err
+// closeWAL closes the write-ahead log if creating or opening the file failed.
+func (b *BTree) closeWAL(err *error) {
+	if *err != nil && b.wal != nil {
+		_ = b.wal.file.Close()
 	}
-	if _, err := b.header.WriteTo(b.file); err != nil {
-		return err
+}
+
+// mapFile replaces the file with a memory mapping of it if MemoryMap is set.
+func (b *BTree) mapFile() error {
+	f, ok := b.file.(*fileStorage)
+	if !ok || !b.mmap {
+		return nil
+	}
+	s, err := mapFile(f)
+	if err != nil {
+		return xerrors.Errorf("failed to map file: %w", err)
 	}
+	b.file = s
 	return nil
 }
 
-func (b *BTree) First(root int) (*Iterator, error) {
-	p, err := b.get(pageNo(root))
-	if err != nil {
-		return nil, xerrors.Errorf("failed to get root: %w", err)
+// recover writes the committed pages in the write-ahead log to the file and reloads the header.
+func (b *BTree) recover() error {
+	if b.wal != nil {
+		for _, f := range b.wal.recover(int(b.PageSize)) {
+			if _, err := b.file.WriteAt(f.data, b.offset(f.pageNo)); err != nil {
+				return xerrors.Errorf("failed to write page: %w", err)
+			}
+		}
+		if err := b.sync(); err != nil {
+			return xerrors.Errorf("failed to sync: %w", err)
+		}
+		if err := b.wal.reset(); err != nil {
+			return xerrors.Errorf("failed to reset log: %w", err)
+		}
 	}
-	switch p.pageType {
-	case leaf:
-		return &Iterator{
-			btree: b,
-			page:  p,
-			index: -1,
-		}, nil
-	case branch:
-		return b.First(int(p.left))
-	default:
-		return nil, xerrors.New("invalid page type")
+
+	if _, err := b.header.ReadFrom(io.NewSectionReader(b.file, 0, int64(b.PageSize))); err != nil {
+		return xerrors.Errorf("failed to read header: %w", err)
+	}
+	n, err := b.file.Size()
+	if err != nil {
+		return xerrors.Errorf("failed to get size: %w", err)
 	}
+	b.pages = pageNo(n / int64(b.PageSize))
+	b.committed = b.header
+	return nil
 }
 
-func (b *BTree) Iterator(root int, key []interface{}) (*Iterator, error) {
-	p, err := b.get(pageNo(root))
-	if err != nil {
-		return nil, xerrors.Errorf("failed to get root: %w", err)
+func (b *BTree) Close() error {
+	b.lock()
+	defer b.unlock()
+	if b.broken {
+		// the log is kept so that the next open replays the commits in it.
+		if b.wal != nil {
+			_ = b.wal.file.Close()
+		}
+		if f, ok := b.file.(io.Closer); ok {
+			return f.Close()
+		}
+		return nil
 	}
-	switch p.pageType {
-	case leaf:
-		i := sort.Search(len(p.cells), func(i int) bool {
-			return values(key).compare(p.cells[i].Key) <= 0
-		})
-		return &Iterator{
-			btree: b,
-			page:  p,
-			index: i - 1,
-		}, nil
-	case branch:
-		i := sort.Search(len(p.cells), func(i int) bool {
-			return values(key).compare(p.cells[i].Key) < 0
-		})
-		i--
-		if i < 0 {
-			return b.Iterator(int(p.left), key)
+	if err := b.checkpoint(); err != nil {
+		return err
+	}
+	if b.wal != nil {
+		if err := b.wal.close(); err != nil {
+			return err
 		}
-		return b.Iterator(int(p.cells[i].Right), key)
-	default:
-		return nil, xerrors.New("invalid page type")
 	}
+	if f, ok := b.file.(io.Closer); ok {
+		return f.Close()
+	}
+	return nil
 }
 
-func (b *BTree) Search(root int, key []interface{}) ([]interface{}, error) {
-	iter, err := b.Iterator(root, key)
-	if err != nil {
-		return nil, err
-	}
-	if err := iter.Next(); err != nil {
-		return nil, err
+// Flush commits the dirty pages in the cache.
+func (b *BTree) Flush() error {
+	b.lock()
+	defer b.unlock()
+	return b.flush()
+}
+
+func (b *BTree) flush() error {
+	if b.tx != nil {
+		return ErrInTransaction
 	}
-	if iter.Key.compare(key) != 0 {
-		return nil, ErrNotFound
+	return b.commit()
+}
+
+// Tx is a transaction begun by Begin. the changes made through it are kept in memory and written at once by Commit.
+// it holds the tree exclusively until Commit or Rollback so that the other readers and writers of the tree wait for it
+// instead of seeing or joining its changes. it isn't safe for concurrent use.
+type Tx struct {
+	btree *BTree
+	done  bool
+}
+
+// Begin starts a transaction. it waits for the other readers and writers to finish. the tree can't be used except
+// through the transaction until it ends. if an operation in a transaction fails, the transaction should be rolled back.
+func (b *BTree) Begin() (*Tx, error) {
+	b.lock()
+	if b.readOnly {
+		b.unlock()
+		return nil, ErrReadOnly
 	}
-	return iter.Value, nil
+	s := b.snapshot()
+	b.tx = &s
+	return &Tx{btree: b}, nil
 }
 
-func (b *BTree) Update(root int, key, val []interface{}) error {
-	iter, err := b.Iterator(root, key)
-	if err != nil {
-		return err
+// Commit writes the changes made in the transaction and releases the tree.
+func (t *Tx) Commit() error {
+	if t.done {
+		return ErrNoTransaction
 	}
-	if err := iter.Next(); err != nil {
+	t.done = true
+	b := t.btree
+	defer b.unlock()
+	s := *b.tx
+	b.tx = nil
+	if err := b.commit(); err != nil {
+		b.rollback(s)
 		return err
 	}
-	if iter.Key.compare(key) != 0 {
-		return ErrNotFound
+	return b.autocheckpoint()
+}
+
+// Rollback discards the changes made in the transaction and releases the tree.
+func (t *Tx) Rollback() error {
+	if t.done {
+		return ErrNoTransaction
 	}
-	iter.Value = val
-	return b.update(iter.page)
+	t.done = true
+	b := t.btree
+	defer b.unlock()
+	b.rollback(*b.tx)
+	b.tx = nil
+	return nil
 }
 
-// TODO: cache
-func (b *BTree) get(i pageNo) (*Page, error) {
-	if i == 0 {
-		return nil, xerrors.Errorf("invalid page number: %d", i)
+// Root returns the root in the header as of the transaction.
+func (t *Tx) Root() int {
+	return t.btree.header.Root()
+}
+
+// UpdateRoot updates the root in the header. see BTree.UpdateRoot.
+func (t *Tx) UpdateRoot(r int) error {
+	if t.done {
+		return ErrNoTransaction
 	}
-	p := NewPage(int(b.PageSize), int(b.CellSize))
-	p.pageNo = i
-	if _, err := b.file.Seek(int64(uint32(i)*b.PageSize), io.SeekStart); err != nil {
-		return nil, xerrors.Errorf("failed to seek start: %w", err)
+	return t.btree.updateRoot(r)
+}
+
+// Search returns the value of the key including the changes made in the transaction. see BTree.Search.
+func (t *Tx) Search(root int, key []interface{}) ([]interface{}, error) {
+	if t.done {
+		return nil, ErrNoTransaction
 	}
-	n, err := p.ReadFrom(b.file)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to read page: %v", err)
+	return t.btree.search(root, key)
+}
+
+// CreateRoot creates an empty tree. see BTree.CreateRoot.
+func (t *Tx) CreateRoot() (int, error) {
+	if t.done {
+		return 0, ErrNoTransaction
 	}
-	if n != int64(b.PageSize) {
-		return nil, errWrongSize
+	return t.btree.createRoot()
+}
+
+// Insert inserts the key and the value. see BTree.Insert.
+func (t *Tx) Insert(root int, key, value []interface{}) (int, error) {
+	if t.done {
+		return 0, ErrNoTransaction
 	}
-	return p, nil
+	return t.btree.insertKey(root, key, value, false)
 }
 
-func (b *BTree) update(p *Page) error {
-	if _, err := b.file.Seek(int64(uint32(p.pageNo)*b.PageSize), io.SeekStart); err != nil {
-		return xerrors.Errorf("failed to seek start: %w", err)
+// InsertAppend inserts the key and the value. see BTree.InsertAppend.
+func (t *Tx) InsertAppend(root int, key, value []interface{}) (int, error) {
+	if t.done {
+		return 0, ErrNoTransaction
 	}
-	n, err := p.WriteTo(b.file)
-	if err != nil {
-		return xerrors.Errorf("failed to write page: %w", err)
+	return t.btree.insertKey(root, key, value, true)
+}
+
+// Update replaces the value of the key. see BTree.Update.
+func (t *Tx) Update(root int, key, val []interface{}) (int, error) {
+	if t.done {
+		return 0, ErrNoTransaction
 	}
-	if n != int64(b.PageSize) {
-		return errWrongSize
+	return t.btree.updateKey(root, key, val)
+}
+
+// Delete deletes the key. see BTree.Delete.
+func (t *Tx) Delete(root int, key []interface{}) (int, error) {
+	if t.done {
+		return 0, ErrNoTransaction
 	}
-	return nil
+	return t.btree.deleteKey(root, key)
 }
 
-func (b *BTree) create(p *Page) error {
-	offset, err := b.file.Seek(0, io.SeekEnd)
-	if err != nil {
-		return xerrors.Errorf("failed to seek end: %w", err)
+// Drop releases all the pages of the tree. see BTree.Drop.
+func (t *Tx) Drop(root int) error {
+	if t.done {
+		return ErrNoTransaction
 	}
-	n, err := p.WriteTo(b.file)
-	if err != nil {
-		return xerrors.Errorf("failed to write page: %w", err)
+	return t.btree.dropTree(root)
+}
+
+// Checkpoint flushes the dirty pages, makes sure the file is synced and discards the write-ahead log.
+func (b *BTree) Checkpoint() error {
+	b.lock()
+	defer b.unlock()
+	return b.checkpoint()
+}
+
+func (b *BTree) checkpoint() error {
+	if err := b.flush(); err != nil {
+		return err
 	}
-	if n != int64(b.PageSize) {
-		return errWrongSize
+	if b.wal == nil {
+		return nil
+	}
+	// the log is the only copy of the pages which the commits failed to write to the file.
+	if err := b.writeBack(b.cache.dirtyPages()); err != nil {
+		return err
+	}
+	if b.syncPolicy != SyncNone {
+		if err := b.sync(); err != nil {
+			return xerrors.Errorf("failed to sync: %w", err)
+		}
+	}
+	if err := b.wal.reset(); err != nil {
+		return xerrors.Errorf("failed to reset log: %w", err)
 	}
-	p.pageNo = pageNo(offset / int64(b.PageSize))
 	return nil
 }
 
-func (b *BTree) CreateRoot() (int, error) {
-	r := NewPage(int(b.PageSize), int(b.CellSize))
-	r.pageType = leaf
-	if err := b.create(r); err != nil {
-		return 0, xerrors.Errorf("failed to create new root: %w", err)
+// snapshot is the state of the tree before an operation.
+type snapshot struct {
+	header header
+	pages  pageNo
+}
+
+// lock takes the tree exclusively for a writer.
+func (b *BTree) lock() {
+	b.writer.Lock()
+	b.mu.Lock()
+}
+
+func (b *BTree) unlock() {
+	b.mu.Unlock()
+	b.writer.Unlock()
+}
+
+func (b *BTree) snapshot() snapshot {
+	return snapshot{
+		header: b.header,
+		pages:  b.pages,
 	}
-	return int(r.pageNo), nil
 }
 
-func (b *BTree) Insert(root int, key, value []interface{}) (int, error) {
-	p, err := b.get(pageNo(root))
-	if err != nil {
-		return 0, xerrors.Errorf("failed to get root page: %w", err)
+// autocommit commits the changes made by an operation outside of transactions if it succeeded. otherwise, it discards them.
+func (b *BTree) autocommit(s snapshot, err *error) {
+	if b.tx != nil {
+		return
+	}
+	if *err == nil {
+		*err = b.commit()
 	}
+	if *err != nil {
+		b.rollback(s)
+		return
+	}
+	*err = b.autocheckpoint()
+}
 
-	m, err := b.insert(p, &cell{Payload: Payload{Key: key, Value: value}})
-	if err != nil {
-		return 0, xerrors.Errorf("failed to insert: %w", err)
+func (b *BTree) rollback(s snapshot) {
+	b.cache.discard()
+	b.header = s.header
+	b.pages = s.pages
+}
+
+// commit logs the images of the dirty pages and the header to the write-ahead log and then writes them to the file.
+// the pages stay dirty unless it succeeds so that the changes can be rolled back.
+//
+// once the log has the images, the commit is done even if writing them to the file fails. the pages stay dirty and are
+// written again by the next commit or checkpoint. without a log, a failure may leave some of the pages written so the
+// tree is broken instead of rolled back.
+func (b *BTree) commit() error {
+	if b.broken {
+		return ErrBroken
+	}
+	ps := b.cache.dirtyPages()
+	if len(ps) == 0 && b.header == b.committed {
+		return nil
 	}
 
-	if m != nil {
-		r := NewPage(int(b.PageSize), int(b.CellSize))
-		r.pageType = branch
-		r.left = pageNo(root)
-		r.cells = r.cells[:1]
-		r.cells[0] = *m
-		if err := b.create(r); err != nil {
-			return 0, xerrors.Errorf("failed to create new root: %w", err)
+	if b.wal == nil {
+		if err := b.writeBack(ps); err != nil {
+			b.broken = true
+			return xerrors.Errorf("%v: %w", err, ErrBroken)
 		}
-		p = r
+		return nil
 	}
 
-	return int(p.pageNo), nil
+	fs := make([]frame, 0, len(ps)+1)
+	for _, p := range ps {
+		var buf bytes.Buffer
+		if _, err := p.WriteTo(&buf); err != nil {
+			return xerrors.Errorf("failed to write page: %w", err)
+		}
+		fs = append(fs, frame{pageNo: p.pageNo, data: buf.Bytes()})
+	}
+	var buf bytes.Buffer
+	if _, err := b.header.WriteTo(&buf); err != nil {
+		return xerrors.Errorf("failed to write header: %w", err)
+	}
+	fs = append(fs, frame{pageNo: 0, data: buf.Bytes()})
+	if err := b.wal.append(fs); err != nil {
+		if xerrors.Is(err, ErrBroken) {
+			b.broken = true
+		}
+		return xerrors.Errorf("failed to append to log: %w", err)
+	}
+	_ = b.writeBack(ps)
+	return nil
 }
 
-func (b *BTree) insert(p *Page, c *cell) (*cell, error) {
-	switch p.pageType {
-	case leaf:
-		if !p.willOverflow() {
+// writeBack writes the pages and then the header to the file and marks the pages clean.
+func (b *BTree) writeBack(ps []*Page) error {
+	if len(ps) == 0 && b.header == b.committed {
+		return nil
+	}
+	for _, p := range ps {
+		if err := b.write(p); err != nil {
+			return xerrors.Errorf("failed to write page: %w", err)
+		}
+	}
+	// the header is published after the pages it points at are durable.
+	if err := b.syncOnCommit(); err != nil {
+		return xerrors.Errorf("failed to sync pages: %w", err)
+	}
+	if err := b.updateHeader(); err != nil {
+		return xerrors.Errorf("failed to update header: %w", err)
+	}
+	if err := b.syncOnCommit(); err != nil {
+		return xerrors.Errorf("failed to sync header: %w", err)
+	}
+	b.cache.clean()
+	return nil
+}
+
+// autocheckpoint checkpoints once the write-ahead log has grown enough.
+func (b *BTree) autocheckpoint() error {
+	if b.wal == nil || b.wal.frames < walCheckpointFrames {
+		return nil
+	}
+	return b.checkpoint()
+}
+
+// syncOnCommit syncs the file if the sync policy requires it on commit. the log already made the commit durable if
+// the file has one.
+func (b *BTree) syncOnCommit() error {
+	switch {
+	case b.syncPolicy == SyncNone:
+		return nil
+	case b.syncPolicy == SyncOnCommit && b.wal != nil:
+		return nil
+	default:
+		return b.sync()
+	}
+}
+
+func (b *BTree) sync() error {
+	if f, ok := b.file.(interface{ Sync() error }); ok {
+		return f.Sync()
+	}
+	return nil
+}
+
+func (b *BTree) Root() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.header.Root()
+}
+
+func (b *BTree) UpdateRoot(r int) error {
+	b.lock()
+	defer b.unlock()
+	return b.updateRoot(r)
+}
+
+func (b *BTree) updateRoot(r int) (err error) {
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	defer b.autocommit(b.snapshot(), &err)
+	b.RootPageNo = pageNo(r)
+	return nil
+}
+
+func (b *BTree) updateHeader() error {
+	var buf bytes.Buffer
+	if _, err := b.header.WriteTo(&buf); err != nil {
+		return err
+	}
+	if _, err := b.file.WriteAt(buf.Bytes(), 0); err != nil {
+		return err
+	}
+	b.committed = b.header
+	return nil
+}
+
+func (b *BTree) First(root int) (*Iterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.first(root)
+}
+
+func (b *BTree) first(root int) (*Iterator, error) {
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get root: %w", err)
+	}
+	switch p.pageType {
+	case leaf:
+		return &Iterator{
+			btree:      b,
+			generation: b.generation,
+			page:       p,
+			index:      -1,
+		}, nil
+	case branch:
+		return b.first(int(p.left))
+	default:
+		return nil, xerrors.New("invalid page type")
+	}
+}
+
+// Last returns an iterator positioned after the last cell so that Prev walks the tree backwards.
+func (b *BTree) Last(root int) (*Iterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.last(root)
+}
+
+func (b *BTree) last(root int) (*Iterator, error) {
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get root: %w", err)
+	}
+	switch p.pageType {
+	case leaf:
+		return &Iterator{
+			btree:      b,
+			generation: b.generation,
+			page:       p,
+			index:      len(p.cells),
+		}, nil
+	case branch:
+		return b.last(int(p.childAt(len(p.cells) - 1)))
+	default:
+		return nil, xerrors.New("invalid page type")
+	}
+}
+
+func (b *BTree) Iterator(root int, key []interface{}) (*Iterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	k, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.iterator(root, k)
+}
+
+// iterator returns an iterator positioned so that Next returns the first cell whose key is greater than or equal to
+// the encoded key k.
+func (b *BTree) iterator(root int, k []byte) (*Iterator, error) {
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get root: %w", err)
+	}
+	switch p.pageType {
+	case leaf:
+		i, err := p.search(func(l []byte) bool {
+			return bytes.Compare(k, l) <= 0
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Iterator{
+			btree:      b,
+			generation: b.generation,
+			page:       p,
+			index:      i - 1,
+		}, nil
+	case branch:
+		n, err := p.child(k)
+		if err != nil {
+			return nil, err
+		}
+		return b.iterator(int(n), k)
+	default:
+		return nil, xerrors.New("invalid page type")
+	}
+}
+
+// Range returns an iterator over the cells whose keys are within the bounds.
+func (b *BTree) Range(root int, lower, upper Bound) (*Iterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if err := lower.encode(); err != nil {
+		return nil, err
+	}
+	if err := upper.encode(); err != nil {
+		return nil, err
+	}
+	iter, err := b.seek(root, lower)
+	if err != nil {
+		return nil, err
+	}
+	iter.lower, iter.upper = lower, upper
+	return iter, nil
+}
+
+// Prefix returns an iterator over the cells whose keys begin with prefix.
+func (b *BTree) Prefix(root int, prefix []interface{}) (*Iterator, error) {
+	return b.Range(root, Bound{Key: prefix}, Bound{Key: prefix})
+}
+
+// seek returns an iterator positioned so that Next returns the first cell whose key is above the lower bound.
+func (b *BTree) seek(root int, lower Bound) (*Iterator, error) {
+	if lower.Key == nil {
+		return b.first(root)
+	}
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get root: %w", err)
+	}
+	i, err := p.search(lower.lowerOf)
+	if err != nil {
+		return nil, err
+	}
+	switch p.pageType {
+	case leaf:
+		return &Iterator{
+			btree:      b,
+			generation: b.generation,
+			page:       p,
+			index:      i - 1,
+		}, nil
+	case branch:
+		// the child at i-1 may contain keys above the bound since its keys are less than the separator at i.
+		return b.seek(int(p.childAt(i-1)), lower)
+	default:
+		return nil, xerrors.New("invalid page type")
+	}
+}
+
+// ReverseIterator returns an iterator positioned so that Prev returns the last cell whose key is less than or equal to key.
+func (b *BTree) ReverseIterator(root int, key []interface{}) (*Iterator, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	k, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.reverseIterator(root, k)
+}
+
+func (b *BTree) reverseIterator(root int, k []byte) (*Iterator, error) {
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get root: %w", err)
+	}
+	switch p.pageType {
+	case leaf:
+		i, err := p.search(func(l []byte) bool {
+			return bytes.Compare(k, l) < 0
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Iterator{
+			btree:      b,
+			generation: b.generation,
+			page:       p,
+			index:      i,
+		}, nil
+	case branch:
+		n, err := p.child(k)
+		if err != nil {
+			return nil, err
+		}
+		return b.reverseIterator(int(n), k)
+	default:
+		return nil, xerrors.New("invalid page type")
+	}
+}
+
+func (b *BTree) Search(root int, key []interface{}) ([]interface{}, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.search(root, key)
+}
+
+func (b *BTree) search(root int, key []interface{}) ([]interface{}, error) {
+	k, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := b.iterator(root, k)
+	if err != nil {
+		return nil, err
+	}
+	switch err := iter.next(); {
+	case err == io.EOF:
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	if !bytes.Equal(iter.key, k) {
+		return nil, ErrNotFound
+	}
+	return iter.Value, nil
+}
+
+// Update replaces the value of the cell with key and returns the new root since the page may split if the cell grows.
+func (b *BTree) Update(root int, key, val []interface{}) (int, error) {
+	b.lock()
+	defer b.unlock()
+	return b.updateKey(root, key, val)
+}
+
+func (b *BTree) updateKey(root int, key, val []interface{}) (_ int, err error) {
+	if b.readOnly {
+		return 0, ErrReadOnly
+	}
+	defer b.autocommit(b.snapshot(), &err)
+	k, err := encodeKey(key)
+	if err != nil {
+		return 0, err
+	}
+	iter, err := b.iterator(root, k)
+	if err != nil {
+		return 0, err
+	}
+	switch err := iter.next(); {
+	case err == io.EOF:
+		return 0, ErrNotFound
+	case err != nil:
+		return 0, err
+	}
+	if !bytes.Equal(iter.key, k) {
+		return 0, ErrNotFound
+	}
+
+	p, i := iter.page, iter.index
+	c := p.cells[i]
+	c.setValue(val)
+	if p.canReplace(i, &c) {
+		p.cells[i] = c
+		return root, b.update(p)
+	}
+
+	// the cell doesn't fit in the page anymore. insert it again so that the page splits.
+	if err := b.releaseOverflow(&p.cells[i]); err != nil {
+		return 0, xerrors.Errorf("failed to release overflow: %w", err)
+	}
+	p.cells = p.cells[:i+copy(p.cells[i:], p.cells[i+1:])]
+	if err := b.update(p); err != nil {
+		return 0, xerrors.Errorf("failed to update: %w", err)
+	}
+	return b.insertTree(root, &cell{Payload: Payload{Key: key, Value: val}}, false)
+}
+
+func (b *BTree) get(i pageNo) (*Page, error) {
+	if b.broken {
+		return nil, ErrBroken
+	}
+	if i == 0 {
+		return nil, xerrors.Errorf("invalid page number: %d", i)
+	}
+	if p, ok := b.cache.get(i); ok {
+		return p, nil
+	}
+	p, err := b.read(i)
+	if err != nil {
+		return nil, err
+	}
+	b.cache.put(p, false)
+	return p, nil
+}
+
+func (b *BTree) read(i pageNo) (*Page, error) {
+	p := b.newPage()
+	p.pageNo = i
+	if err := b.view(b.offset(i), int(b.PageSize), func(buf []byte) error {
+		if b.Checksums != 0 && buf[1] != pageChecksum(buf) {
+			return &ErrCorruptPage{PageNo: int(i)}
+		}
+		if err := p.decode(buf); err != nil {
+			return xerrors.Errorf("failed to read page: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for i := range p.cells {
+		if err := b.inflate(&p.cells[i]); err != nil {
+			return nil, xerrors.Errorf("failed to inflate cell: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// viewer is a storage which lends its bytes without copying them such as a memory mapped file.
+type viewer interface {
+	view(off int64, n int, f func(buf []byte) error) error
+}
+
+// view calls f with n bytes of the storage at off. buf is only valid while f runs.
+func (b *BTree) view(off int64, n int, f func(buf []byte) error) error {
+	if v, ok := b.file.(viewer); ok {
+		return v.view(off, n, f)
+	}
+	buf := make([]byte, n)
+	if _, err := b.file.ReadAt(buf, off); err != nil {
+		return xerrors.Errorf("failed to read page: %w", err)
+	}
+	return f(buf)
+}
+
+func (b *BTree) update(p *Page) error {
+	if err := b.spillAll(p); err != nil {
+		return err
+	}
+	b.cache.put(p, true)
+	return nil
+}
+
+func (b *BTree) write(p *Page) error {
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); err != nil {
+		return xerrors.Errorf("failed to write page: %w", err)
+	}
+	n, err := b.file.WriteAt(buf.Bytes(), b.offset(p.pageNo))
+	if err != nil {
+		return xerrors.Errorf("failed to write page: %w", err)
+	}
+	if n != int(b.PageSize) {
+		return errWrongSize
+	}
+	return nil
+}
+
+func (b *BTree) offset(i pageNo) int64 {
+	return int64(i) * int64(b.PageSize)
+}
+
+func (b *BTree) newPage() *Page {
+	p := newPage(int(b.PageSize), int(b.CellSize), b.Format)
+	p.keys = b.Keys
+	return p
+}
+
+func (b *BTree) create(p *Page) error {
+	if err := b.spillAll(p); err != nil {
+		return err
+	}
+	if b.FreePageNo != 0 {
+		return b.reuse(p)
+	}
+	p.pageNo = b.pages
+	b.pages++
+	b.cache.put(p, true)
+	return nil
+}
+
+func (b *BTree) CreateRoot() (int, error) {
+	b.lock()
+	defer b.unlock()
+	return b.createRoot()
+}
+
+func (b *BTree) createRoot() (_ int, err error) {
+	if b.readOnly {
+		return 0, ErrReadOnly
+	}
+	defer b.autocommit(b.snapshot(), &err)
+	r := b.newPage()
+	r.pageType = leaf
+	if err := b.create(r); err != nil {
+		return 0, xerrors.Errorf("failed to create new root: %w", err)
+	}
+	return int(r.pageNo), nil
+}
+
+func (b *BTree) Insert(root int, key, value []interface{}) (int, error) {
+	b.lock()
+	defer b.unlock()
+	return b.insertKey(root, key, value, false)
+}
+
+// InsertAppend is Insert for a tree whose keys mostly increase like sequence numbers. a full page at the right edge of
+// the tree is split unevenly when a key greater than any other is inserted. the old page stays full and the new one
+// gets the key alone so that the tree doesn't end up with half-full pages. the other keys are inserted as Insert does.
+func (b *BTree) InsertAppend(root int, key, value []interface{}) (int, error) {
+	b.lock()
+	defer b.unlock()
+	return b.insertKey(root, key, value, true)
+}
+
+func (b *BTree) insertKey(root int, key, value []interface{}, appending bool) (_ int, err error) {
+	if b.readOnly {
+		return 0, ErrReadOnly
+	}
+	defer b.autocommit(b.snapshot(), &err)
+	return b.insertTree(root, &cell{Payload: Payload{Key: key, Value: value}}, appending)
+}
+
+// insertTree inserts c into the tree rooted at root and returns the root. the root keeps its page number even if it
+// splits so that the readers which looked it up before still find every key. see InsertAppend for appending.
+func (b *BTree) insertTree(root int, c *cell, appending bool) (int, error) {
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return 0, xerrors.Errorf("failed to get root page: %w", err)
+	}
+
+	m, err := b.insert(p, c, appending)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to insert: %w", err)
+	}
+	if m == nil {
+		return root, nil
+	}
+
+	// the left half moves out to a new page and the root becomes the branch over both halves.
+	l := b.newPage()
+	l.pageType = p.pageType
+	l.left, l.next, l.prev = p.left, p.next, p.prev
+	l.cells = append(l.cells, p.cells...)
+	if err := b.create(l); err != nil {
+		return 0, xerrors.Errorf("failed to create left: %w", err)
+	}
+	if l.pageType == leaf && l.next != 0 {
+		n, err := b.get(l.next)
+		if err != nil {
+			return 0, xerrors.Errorf("failed to get next: %w", err)
+		}
+		n.prev = l.pageNo
+		if err := b.update(n); err != nil {
+			return 0, xerrors.Errorf("failed to update next: %w", err)
+		}
+	}
+	p.pageType = branch
+	p.left, p.next, p.prev = l.pageNo, 0, 0
+	p.cells = append(p.cells[:0], *m)
+	if err := b.update(p); err != nil {
+		return 0, xerrors.Errorf("failed to update root: %w", err)
+	}
+	return root, nil
+}
+
+// insert inserts c into the subtree of p. appending tells if p is at the right edge of the tree inserted by
+// InsertAppend.
+func (b *BTree) insert(p *Page, c *cell, appending bool) (*cell, error) {
+	switch p.pageType {
+	case leaf:
+		if !p.willOverflow(c) {
 			if err := p.Insert(c); err != nil {
 				return nil, xerrors.Errorf("failed to insert: %w", err)
 			}
@@ -326,7 +1310,7 @@ func (b *BTree) insert(p *Page, c *cell) (*cell, error) {
 			return nil, nil
 		}
 
-		r, err := p.InsertSplit(c)
+		r, err := p.insertSplit(c, appending)
 		if err != nil {
 			return nil, xerrors.Errorf("failed to insert and split: %w", err)
 		}
@@ -349,13 +1333,21 @@ func (b *BTree) insert(p *Page, c *cell) (*cell, error) {
 				return nil, xerrors.Errorf("failed to update next: %w", err)
 			}
 		}
-		return &cell{Payload: Payload{Key: r.cells[0].Key, Right: r.pageNo}}, nil
+		return &cell{Payload: Payload{Key: separator(p.cells[len(p.cells)-1].Key, r.cells[0].Key), Right: r.pageNo}}, nil
 	case branch:
-		n, err := b.get(p.child(c.Key))
+		ck, err := c.sortKey()
+		if err != nil {
+			return nil, err
+		}
+		i, err := p.childIndex(ck)
+		if err != nil {
+			return nil, err
+		}
+		n, err := b.get(p.childAt(i))
 		if err != nil {
 			return nil, xerrors.Errorf("failed to get child: %w", err)
 		}
-		m, err := b.insert(n, c)
+		m, err := b.insert(n, c, appending && i == len(p.cells)-1)
 		if err != nil {
 			return nil, xerrors.Errorf("failed to insert: %w", err)
 		}
@@ -363,7 +1355,7 @@ func (b *BTree) insert(p *Page, c *cell) (*cell, error) {
 			return nil, nil
 		}
 
-		if !p.willOverflow() {
+		if !p.willOverflow(m) {
 			if err := p.Insert(m); err != nil {
 				return nil, xerrors.Errorf("failed to insert: %w", err)
 			}
@@ -372,15 +1364,537 @@ func (b *BTree) insert(p *Page, c *cell) (*cell, error) {
 			}
 			return nil, nil
 		}
-		r, k, err := p.InsertSplitMiddle(m)
+		r, k, err := p.insertSplitMiddle(m, appending)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to insert and split: %w", err)
+		}
 		if err := b.create(r); err != nil {
 			return nil, xerrors.Errorf("failed to create right: %w", err)
 		}
 		if err := b.update(p); err != nil {
 			return nil, xerrors.Errorf("failed to update: %w", err)
 		}
-		return &cell{Payload: Payload{Key: k, Right: r.pageNo}}, nil
+		k.setRight(r.pageNo)
+		return k, nil
 	default:
 		return nil, xerrors.Errorf("invalid page type: %s", p.pageType)
 	}
 }
+
+func (b *BTree) Delete(root int, key []interface{}) (int, error) {
+	b.lock()
+	defer b.unlock()
+	return b.deleteKey(root, key)
+}
+
+func (b *BTree) deleteKey(root int, key []interface{}) (_ int, err error) {
+	if b.readOnly {
+		return 0, ErrReadOnly
+	}
+	defer b.autocommit(b.snapshot(), &err)
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return 0, xerrors.Errorf("failed to get root page: %w", err)
+	}
+
+	k, err := encodeKey(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.delete(p, k); err != nil {
+		return 0, xerrors.Errorf("failed to delete: %w", err)
+	}
+
+	if p.pageType == branch && len(p.cells) == 0 {
+		// the only child moves up into the root so that the root keeps its page number.
+		n, err := b.get(p.left)
+		if err != nil {
+			return 0, xerrors.Errorf("failed to get child: %w", err)
+		}
+		o := b.newPage()
+		o.pageNo = n.pageNo
+		n.pageNo = p.pageNo
+		if err := b.update(n); err != nil {
+			return 0, xerrors.Errorf("failed to update root: %w", err)
+		}
+		if err := b.release(o); err != nil {
+			return 0, xerrors.Errorf("failed to release child: %w", err)
+		}
+	}
+
+	return root, nil
+}
+
+func (b *BTree) delete(p *Page, k []byte) error {
+	switch p.pageType {
+	case leaf:
+		i, ok, err := p.find(k)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotFound
+		}
+		if err := b.releaseOverflow(&p.cells[i]); err != nil {
+			return xerrors.Errorf("failed to release overflow: %w", err)
+		}
+		p.cells = p.cells[:i+copy(p.cells[i:], p.cells[i+1:])]
+		if err := b.update(p); err != nil {
+			return xerrors.Errorf("failed to update: %w", err)
+		}
+		return nil
+	case branch:
+		i, err := p.childIndex(k)
+		if err != nil {
+			return err
+		}
+		n, err := b.get(p.childAt(i))
+		if err != nil {
+			return xerrors.Errorf("failed to get child: %w", err)
+		}
+		if err := b.delete(n, k); err != nil {
+			return xerrors.Errorf("failed to delete: %w", err)
+		}
+		if !n.underflow() {
+			return nil
+		}
+		if err := b.rebalance(p, i, n); err != nil {
+			return xerrors.Errorf("failed to rebalance: %w", err)
+		}
+		return nil
+	default:
+		return xerrors.Errorf("invalid page type: %s", p.pageType)
+	}
+}
+
+// rebalance fixes the underflown child n at index i of the branch page p by borrowing a cell from or merging with its sibling.
+func (b *BTree) rebalance(p *Page, i int, n *Page) error {
+	// only the root can be left with a single child and it collapses in deleteKey.
+	if len(p.cells) == 0 {
+		return nil
+	}
+
+	// s is the index of the separator cell between the left page l and the right page r.
+	var l, r *Page
+	s := i + 1
+	if s < len(p.cells) {
+		sib, err := b.get(p.cells[s].Right)
+		if err != nil {
+			return xerrors.Errorf("failed to get right sibling: %w", err)
+		}
+		l, r = n, sib
+	} else {
+		s = i
+		sib, err := b.get(p.childAt(i - 1))
+		if err != nil {
+			return xerrors.Errorf("failed to get left sibling: %w", err)
+		}
+		l, r = sib, n
+	}
+
+	// a branch without cells takes one from its sibling even if the sibling underflows then.
+	empty := n.pageType == branch && len(n.cells) == 0
+
+	switch {
+	case r != n && (r.canLend(0) || empty && len(r.cells) > 1) && p.canReplace(s, rightSeparator(p.cells[s], r)):
+		if err := b.borrowRight(p, s, l, r); err != nil {
+			return xerrors.Errorf("failed to borrow from right: %w", err)
+		}
+	case l != n && (l.canLend(len(l.cells)-1) || empty && len(l.cells) > 1) && p.canReplace(s, leftSeparator(p.cells[s], l)):
+		if err := b.borrowLeft(p, s, l, r); err != nil {
+			return xerrors.Errorf("failed to borrow from left: %w", err)
+		}
+	case l.canMerge(r, mergedSeparator(p.cells[s], r)):
+		if err := b.merge(p, s, l, r); err != nil {
+			return xerrors.Errorf("failed to merge: %w", err)
+		}
+		if err := b.update(l); err != nil {
+			return xerrors.Errorf("failed to update left: %w", err)
+		}
+		if err := b.update(p); err != nil {
+			return xerrors.Errorf("failed to update parent: %w", err)
+		}
+		return nil
+	case empty:
+		return xerrors.Errorf("no room for a separator in page %d", p.pageNo)
+	default:
+		return nil
+	}
+
+	if err := b.update(l); err != nil {
+		return xerrors.Errorf("failed to update left: %w", err)
+	}
+	if err := b.update(r); err != nil {
+		return xerrors.Errorf("failed to update right: %w", err)
+	}
+	if err := b.update(p); err != nil {
+		return xerrors.Errorf("failed to update parent: %w", err)
+	}
+	return nil
+}
+
+// rightSeparator returns the separator sep after borrowing from r.
+func rightSeparator(sep cell, r *Page) *cell {
+	switch r.pageType {
+	case leaf:
+		sep.setKey(&cell{Payload: Payload{Key: separator(r.cells[0].Key, r.cells[1].Key)}})
+	case branch:
+		sep.setKey(&r.cells[0])
+	}
+	return &sep
+}
+
+// leftSeparator returns the separator sep after borrowing from l.
+func leftSeparator(sep cell, l *Page) *cell {
+	n := len(l.cells)
+	if l.pageType == leaf && n > 1 {
+		sep.setKey(&cell{Payload: Payload{Key: separator(l.cells[n-2].Key, l.cells[n-1].Key)}})
+		return &sep
+	}
+	sep.setKey(&l.cells[n-1])
+	return &sep
+}
+
+// separator returns the shortest key s such that l < s <= r to separate leaves. it keeps the values of r up to the
+// first one which differs from l and shortens it.
+func separator(l, r values) values {
+	for i := range r {
+		if i == len(l) {
+			// l is a prefix of r. NULL is the least value.
+			return append(append(values{}, l...), nil)
+		}
+		switch d, err := (values{l[i]}).compare(values{r[i]}); {
+		case err != nil || d > 0:
+			return r
+		case d < 0:
+			return append(append(values{}, r[:i]...), shorten(l[i], r[i]))
+		}
+	}
+	return r
+}
+
+// shorten returns the shortest value v such that l < v <= r where l < r.
+func shorten(l, r interface{}) interface{} {
+	switch r := r.(type) {
+	case string:
+		if l, ok := l.(string); ok {
+			return r[:commonPrefix([]byte(l), []byte(r))+1]
+		}
+		return ""
+	case []byte:
+		if l, ok := l.([]byte); ok {
+			return append([]byte{}, r[:commonPrefix(l, r)+1]...)
+		}
+		return []byte{}
+	default:
+		return r
+	}
+}
+
+// commonPrefix returns the length of the common prefix of a and b.
+func commonPrefix(a, b []byte) int {
+	var n int
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// mergedSeparator returns the separator sep pointing to the left of r as it's moved into the merged branch.
+func mergedSeparator(sep cell, r *Page) *cell {
+	m := cell{Payload: Payload{Right: r.left}}
+	m.setKey(&sep)
+	return &m
+}
+
+// borrowRight moves the first cell of r to the end of l and fixes the separator p.cells[s].
+func (b *BTree) borrowRight(p *Page, s int, l, r *Page) error {
+	switch l.pageType {
+	case leaf:
+		p.cells[s] = *rightSeparator(p.cells[s], r)
+		l.cells = append(l.cells, r.cells[0])
+		r.cells = r.cells[:copy(r.cells, r.cells[1:])]
+	case branch:
+		l.cells = append(l.cells, cell{Payload: Payload{Key: p.cells[s].Key, Right: r.left}})
+		p.cells[s].setKey(&r.cells[0])
+		r.left = r.cells[0].Right
+		if err := b.releaseOverflow(&r.cells[0]); err != nil {
+			return err
+		}
+		r.cells = r.cells[:copy(r.cells, r.cells[1:])]
+	}
+	return nil
+}
+
+// borrowLeft moves the last cell of l to the beginning of r and fixes the separator p.cells[s].
+func (b *BTree) borrowLeft(p *Page, s int, l, r *Page) error {
+	sep := leftSeparator(p.cells[s], l)
+	last := l.cells[len(l.cells)-1]
+	l.cells = l.cells[:len(l.cells)-1]
+	r.cells = append(r.cells, cell{})
+	copy(r.cells[1:], r.cells)
+	switch l.pageType {
+	case leaf:
+		r.cells[0] = last
+		p.cells[s] = *sep
+	case branch:
+		r.cells[0] = cell{Payload: Payload{Key: p.cells[s].Key, Right: r.left}}
+		r.left = last.Right
+		p.cells[s] = *sep
+		if err := b.releaseOverflow(&last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge moves all the cells of r into l, removes the separator p.cells[s] and releases r.
+func (b *BTree) merge(p *Page, s int, l, r *Page) error {
+	switch l.pageType {
+	case leaf:
+		l.cells = append(l.cells, r.cells...)
+		l.next = r.next
+		if r.next != 0 {
+			n, err := b.get(r.next)
+			if err != nil {
+				return xerrors.Errorf("failed to get next: %w", err)
+			}
+			n.prev = l.pageNo
+			if err := b.update(n); err != nil {
+				return xerrors.Errorf("failed to update next: %w", err)
+			}
+		}
+	case branch:
+		l.cells = append(l.cells, *mergedSeparator(p.cells[s], r))
+		l.cells = append(l.cells, r.cells...)
+	}
+	if err := b.releaseOverflow(&p.cells[s]); err != nil {
+		return xerrors.Errorf("failed to release overflow: %w", err)
+	}
+	p.cells = p.cells[:s+copy(p.cells[s:], p.cells[s+1:])]
+	if err := b.release(r); err != nil {
+		return xerrors.Errorf("failed to release right: %w", err)
+	}
+	return nil
+}
+
+// reuse writes p to the page at the head of the free list.
+func (b *BTree) reuse(p *Page) error {
+	f, err := b.get(b.FreePageNo)
+	if err != nil {
+		return xerrors.Errorf("failed to get free page: %w", err)
+	}
+	if f.pageType != free {
+		return xerrors.Errorf("invalid page type: %s", f.pageType)
+	}
+	p.pageNo = f.pageNo
+	if err := b.update(p); err != nil {
+		return xerrors.Errorf("failed to update: %w", err)
+	}
+	b.FreePageNo = f.next
+	b.FreePageCount--
+	return nil
+}
+
+// release pushes the page p to the free list.
+func (b *BTree) release(p *Page) error {
+	p.pageType = free
+	p.next = b.FreePageNo
+	p.prev = 0
+	p.left = 0
+	p.cells = p.cells[:0]
+	p.data = nil
+	if err := b.update(p); err != nil {
+		return xerrors.Errorf("failed to update: %w", err)
+	}
+	b.FreePageNo = p.pageNo
+	b.FreePageCount++
+	return nil
+}
+
+// Drop releases all the pages of the tree rooted at root.
+func (b *BTree) Drop(root int) error {
+	b.lock()
+	defer b.unlock()
+	return b.dropTree(root)
+}
+
+func (b *BTree) dropTree(root int) (err error) {
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	defer b.autocommit(b.snapshot(), &err)
+	return b.drop(root)
+}
+
+func (b *BTree) drop(root int) error {
+	p, err := b.get(pageNo(root))
+	if err != nil {
+		return xerrors.Errorf("failed to get page: %w", err)
+	}
+	switch p.pageType {
+	case leaf:
+	case branch:
+		if err := b.drop(int(p.left)); err != nil {
+			return err
+		}
+		for _, c := range p.cells {
+			if err := b.drop(int(c.Right)); err != nil {
+				return err
+			}
+		}
+	default:
+		return xerrors.Errorf("invalid page type: %s", p.pageType)
+	}
+	for i := range p.cells {
+		if err := b.releaseOverflow(&p.cells[i]); err != nil {
+			return xerrors.Errorf("failed to release overflow: %w", err)
+		}
+	}
+	if err := b.release(p); err != nil {
+		return xerrors.Errorf("failed to release: %w", err)
+	}
+	return nil
+}
+
+// inflate reads the rest of the encoded payload from the overflow pages and decodes it.
+func (b *BTree) inflate(c *cell) error {
+	if c.inflated() {
+		return nil
+	}
+	for o := c.overflow; !c.inflated(); {
+		if o == 0 {
+			return xerrors.New("broken overflow chain")
+		}
+		p, err := b.get(o)
+		if err != nil {
+			return xerrors.Errorf("failed to get overflow page: %w", err)
+		}
+		if p.pageType != overflow {
+			return xerrors.Errorf("invalid page type: %s", p.pageType)
+		}
+		c.raw = append(c.raw, p.data...)
+		o = p.next
+	}
+	return c.decode()
+}
+
+func (b *BTree) spillAll(p *Page) error {
+	prefix := p.keyPrefix(p.cells)
+	for i := range p.cells {
+		// the cells encoded with the same prefix are spilled already.
+		if p.cells[i].raw != nil && bytes.Equal(p.cells[i].prefix, prefix) {
+			continue
+		}
+		p.cells[i].prefix = prefix
+		if err := b.spill(&p.cells[i]); err != nil {
+			return xerrors.Errorf("failed to spill cell: %w", err)
+		}
+	}
+	return nil
+}
+
+// spill writes the part of the encoded payload which doesn't fit in the cell into a chain of overflow pages.
+// the existing chain of the cell is reused if any.
+// the key is encoded without the prefix of the cell.
+func (b *BTree) spill(c *cell) error {
+	raw, err := c.encode(b.Keys, c.prefix)
+	if err != nil {
+		return err
+	}
+
+	switch n := int(b.CellSize) - cellHeaderSize; {
+	case len(raw) <= n:
+		if err := b.releaseOverflow(c); err != nil {
+			return err
+		}
+	case c.overflow != 0 && bytes.Equal(raw, c.raw):
+	default:
+		if err := b.writeOverflow(c, raw[n:]); err != nil {
+			return err
+		}
+	}
+
+	// decode it again so that the cached values have the same types as the ones read from the file.
+	c.raw, c.length = raw, uint32(len(raw))
+	c.Payload = Payload{}
+	return c.decode()
+}
+
+func (b *BTree) writeOverflow(c *cell, rest []byte) error {
+	chain, err := b.overflowChain(c.overflow)
+	if err != nil {
+		return err
+	}
+
+	var chunks [][]byte
+	for m := int(b.PageSize) - pageHeaderSize; len(rest) > 0; {
+		if len(rest) < m {
+			m = len(rest)
+		}
+		chunks = append(chunks, rest[:m])
+		rest = rest[m:]
+	}
+
+	// write from the tail so that each page knows the next.
+	var next pageNo
+	for i := len(chunks) - 1; i >= 0; i-- {
+		p := b.newPage()
+		p.pageType = overflow
+		p.next = next
+		p.data = chunks[i]
+		if i < len(chain) {
+			p.pageNo = chain[i]
+			err = b.update(p)
+		} else {
+			err = b.create(p)
+		}
+		if err != nil {
+			return xerrors.Errorf("failed to write overflow page: %w", err)
+		}
+		next = p.pageNo
+	}
+	for i := len(chunks); i < len(chain); i++ {
+		p := b.newPage()
+		p.pageNo = chain[i]
+		if err := b.release(p); err != nil {
+			return err
+		}
+	}
+
+	c.overflow = next
+	return nil
+}
+
+// releaseOverflow releases the chain of overflow pages of the cell.
+func (b *BTree) releaseOverflow(c *cell) error {
+	chain, err := b.overflowChain(c.overflow)
+	if err != nil {
+		return err
+	}
+	for _, o := range chain {
+		p := b.newPage()
+		p.pageNo = o
+		if err := b.release(p); err != nil {
+			return err
+		}
+	}
+	c.overflow = 0
+	return nil
+}
+
+func (b *BTree) overflowChain(o pageNo) ([]pageNo, error) {
+	var chain []pageNo
+	for o != 0 {
+		p, err := b.get(o)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get overflow page: %w", err)
+		}
+		if p.pageType != overflow {
+			return nil, xerrors.Errorf("invalid page type: %s", p.pageType)
+		}
+		chain = append(chain, o)
+		o = p.next
+	}
+	return chain, nil
+}
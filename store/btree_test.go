@@ -1,12 +1,21 @@
 package store
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
 )
 
 func TestCreate(t *testing.T) {
@@ -32,12 +41,12 @@ func TestCreate(t *testing.T) {
 		0x00, 0x00, 0x00, 0x20, // cell size
 
 		0x00, 0x00, 0x00, 0x00, // root page
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // free page
+		0x00, 0x00, 0x00, 0x00, // free page count
+		0x00, 0x00, 0x00, 0x01, // format: slotted cells
 
-		0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02, // keys: compressed keys
+		0x00, 0x00, 0x00, 0x01, // checksums
 		0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00,
 
@@ -73,7 +82,7 @@ func TestBTree_Iterator(t *testing.T) {
 	assert.NoError(t, err)
 	defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
 
-	b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+	b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
 	assert.NoError(t, err)
 
 	l1 := NewPage(128, 32)
@@ -210,17 +219,183 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.Equal(values{uint64(25)}, iter.Key)
 		assert.Equal(values{"25"}, iter.Value)
 
-		assert.Equal(ErrNotFound, iter.Next())
+		assert.Equal(io.EOF, iter.Next())
+	})
+
+	t.Run("iterate backwards from the last", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Last(int(r.pageNo))
+		assert.NoError(err)
+
+		for _, k := range []uint64{25, 20, 16, 15, 13, 12, 11, 10, 9, 4, 1} {
+			assert.NoError(iter.Prev())
+			assert.Equal(values{k}, iter.Key)
+			assert.Equal(values{fmt.Sprintf("%d", k)}, iter.Value)
+		}
+
+		assert.Equal(io.EOF, iter.Prev())
+	})
+
+	t.Run("iterate backwards from 12", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.ReverseIterator(int(r.pageNo), values{12})
+		assert.NoError(err)
+
+		for _, k := range []uint64{12, 11, 10, 9, 4, 1} {
+			assert.NoError(iter.Prev())
+			assert.Equal(values{k}, iter.Key)
+		}
+
+		assert.Equal(io.EOF, iter.Prev())
+	})
+
+	t.Run("iterate backwards from 14", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.ReverseIterator(int(r.pageNo), values{14})
+		assert.NoError(err)
+
+		assert.NoError(iter.Prev())
+		assert.Equal(values{uint64(13)}, iter.Key)
+
+		assert.NoError(iter.Prev())
+		assert.Equal(values{uint64(12)}, iter.Key)
+	})
+
+	t.Run("turn around", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Iterator(int(r.pageNo), values{20})
+		assert.NoError(err)
+
+		assert.NoError(iter.Next())
+		assert.Equal(values{uint64(20)}, iter.Key)
+
+		assert.NoError(iter.Next())
+		assert.Equal(values{uint64(25)}, iter.Key)
+
+		assert.Equal(io.EOF, iter.Next())
+
+		assert.NoError(iter.Prev())
+		assert.Equal(values{uint64(25)}, iter.Key)
+
+		assert.NoError(iter.Prev())
+		assert.Equal(values{uint64(20)}, iter.Key)
+
+		assert.NoError(iter.Prev())
+		assert.Equal(values{uint64(16)}, iter.Key)
+
+		assert.NoError(iter.Prev())
+		assert.Equal(values{uint64(15)}, iter.Key)
+
+		assert.NoError(iter.Next())
+		assert.Equal(values{uint64(16)}, iter.Key)
 	})
 }
 
-func TestBTree_Search(t *testing.T) {
+func TestBTree_Range(t *testing.T) {
 	dir, err := ioutil.TempDir("", "test")
 	assert.NoError(t, err)
 	defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
 
 	b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
 	assert.NoError(t, err)
+	defer func() { assert.NoError(t, b.Close()) }()
+
+	r, err := b.CreateRoot()
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			r, err = b.Insert(r, values{i, j}, values{i*10 + j})
+			assert.NoError(t, err)
+		}
+	}
+
+	// keys returns the keys of the rest of the cells as numbers.
+	keys := func(t *testing.T, iter *Iterator) []uint64 {
+		var ks []uint64
+		for {
+			err := iter.Next()
+			if err != nil {
+				assert.Equal(t, io.EOF, err)
+				return ks
+			}
+			ks = append(ks, iter.Value[0].(uint64))
+		}
+	}
+
+	t.Run("inclusive", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Range(r, Bound{Key: values{3, 5}}, Bound{Key: values{4, 2}})
+		assert.NoError(err)
+		assert.Equal([]uint64{35, 36, 37, 38, 39, 40, 41, 42}, keys(t, iter))
+	})
+
+	t.Run("exclusive", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Range(r, Bound{Key: values{3, 5}, Exclusive: true}, Bound{Key: values{4, 2}, Exclusive: true})
+		assert.NoError(err)
+		assert.Equal([]uint64{36, 37, 38, 39, 40, 41}, keys(t, iter))
+	})
+
+	t.Run("unbounded", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Range(r, Bound{}, Bound{Key: values{0, 3}})
+		assert.NoError(err)
+		assert.Equal([]uint64{0, 1, 2, 3}, keys(t, iter))
+
+		iter, err = b.Range(r, Bound{Key: values{9, 7}}, Bound{})
+		assert.NoError(err)
+		assert.Equal([]uint64{97, 98, 99}, keys(t, iter))
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Prefix(r, values{7})
+		assert.NoError(err)
+		assert.Equal([]uint64{70, 71, 72, 73, 74, 75, 76, 77, 78, 79}, keys(t, iter))
+
+		iter, err = b.Prefix(r, values{10})
+		assert.NoError(err)
+		assert.Empty(keys(t, iter))
+	})
+
+	t.Run("partial bounds", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Range(r, Bound{Key: values{2}, Exclusive: true}, Bound{Key: values{4}, Exclusive: true})
+		assert.NoError(err)
+		assert.Equal([]uint64{30, 31, 32, 33, 34, 35, 36, 37, 38, 39}, keys(t, iter))
+	})
+
+	t.Run("backwards", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Prefix(r, values{5})
+		assert.NoError(err)
+		assert.Len(keys(t, iter), 10)
+
+		var ks []uint64
+		for iter.Prev() == nil {
+			ks = append(ks, iter.Value[0].(uint64))
+		}
+		assert.Equal([]uint64{59, 58, 57, 56, 55, 54, 53, 52, 51, 50}, ks)
+	})
+}
+
+func TestBTree_Search(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
+
+	b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
+	assert.NoError(t, err)
 
 	l1 := NewPage(128, 32)
 	l1.pageType = leaf
@@ -393,6 +568,29 @@ func TestBTree_Search(t *testing.T) {
 		assert.NoError(err)
 		assert.Equal([]interface{}{"25"}, v)
 	})
+
+	t.Run("copy", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// modifying the results doesn't affect the tree.
+		v, err := b.Search(int(r.pageNo), []interface{}{1})
+		assert.NoError(err)
+		v[0] = "x"
+		iter, err := b.First(int(r.pageNo))
+		assert.NoError(err)
+		assert.NoError(iter.Next())
+		iter.Key[0] = 2
+		iter.Value[0] = "y"
+
+		v, err = b.Search(int(r.pageNo), []interface{}{1})
+		assert.NoError(err)
+		assert.Equal([]interface{}{"1"}, v)
+		iter, err = b.First(int(r.pageNo))
+		assert.NoError(err)
+		assert.NoError(iter.Next())
+		assert.Equal(values{uint64(1)}, iter.Key)
+		assert.Equal(values{"1"}, iter.Value)
+	})
 }
 
 func TestBTree_Insert(t *testing.T) {
@@ -405,7 +603,7 @@ func TestBTree_Insert(t *testing.T) {
 		assert.NoError(err)
 		defer func() { assert.NoError(os.RemoveAll(dir)) }()
 
-		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
 		assert.NoError(err)
 
 		l1 := NewPage(128, 32)
@@ -485,7 +683,7 @@ func TestBTree_Insert(t *testing.T) {
 		assert.NoError(err)
 		defer func() { assert.NoError(os.RemoveAll(dir)) }()
 
-		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
 		assert.NoError(err)
 
 		l1 := NewPage(128, 32)
@@ -577,7 +775,7 @@ func TestBTree_Insert(t *testing.T) {
 		assert.NoError(err)
 		defer func() { assert.NoError(os.RemoveAll(dir)) }()
 
-		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
 		assert.NoError(err)
 
 		l1 := NewPage(128, 32)
@@ -682,7 +880,7 @@ func TestBTree_Insert(t *testing.T) {
 		assert.NoError(err)
 		defer func() { assert.NoError(os.RemoveAll(dir)) }()
 
-		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
 		assert.NoError(err)
 
 		l1 := NewPage(128, 32)
@@ -799,7 +997,7 @@ func TestBTree_Insert(t *testing.T) {
 		assert.NoError(err)
 		defer func() { assert.NoError(os.RemoveAll(dir)) }()
 
-		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
 		assert.NoError(err)
 
 		l1 := NewPage(128, 32)
@@ -929,7 +1127,7 @@ func TestBTree_Insert(t *testing.T) {
 		assert.NoError(err)
 		defer func() { assert.NoError(os.RemoveAll(dir)) }()
 
-		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
 		assert.NoError(err)
 
 		l1 := NewPage(128, 32)
@@ -991,16 +1189,16 @@ func TestBTree_Insert(t *testing.T) {
 
 		n, err := b.Insert(int(r.pageNo), values{12}, values{"12"})
 		assert.NoError(err)
-		assert.Equal(pageNo(8), pageNo(n)) // new root
+		assert.Equal(pageNo(5), pageNo(n)) // the root keeps its page number
 
-		r, err = b.get(pageNo(8))
+		r, err = b.get(pageNo(5))
 		assert.NoError(err)
-		assert.Equal(pageNo(5), r.left)
+		assert.Equal(pageNo(8), r.left)
 		assert.Len(r.cells, 1)
 		assert.Equal(values{uint64(13)}, r.cells[0].Key)
 		assert.Equal(pageNo(7), r.cells[0].Right)
 
-		i1, err := b.get(pageNo(5))
+		i1, err := b.get(pageNo(8))
 		assert.NoError(err)
 		assert.Equal(branch, i1.pageType)
 		assert.Equal(pageNo(1), i1.left)
@@ -1076,3 +1274,1598 @@ func TestBTree_Insert(t *testing.T) {
 		assert.Equal(values{"25"}, l5.cells[2].Value)
 	})
 }
+
+func TestBTree_Delete(t *testing.T) {
+	t.Run("delete from leaf", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for _, k := range []int{1, 2, 3} {
+			r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+
+		n, err := b.Delete(r, values{2})
+		assert.NoError(err)
+		assert.Equal(r, n)
+
+		l, err := b.get(pageNo(r))
+		assert.NoError(err)
+		assert.Equal(leaf, l.pageType)
+		assert.Len(l.cells, 2)
+		assert.Equal(values{uint64(1)}, l.cells[0].Key)
+		assert.Equal(values{uint64(3)}, l.cells[1].Key)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		r, err = b.Insert(r, values{1}, values{"1"})
+		assert.NoError(err)
+
+		_, err = b.Delete(r, values{2})
+		assert.True(xerrors.Is(err, ErrNotFound))
+	})
+
+	t.Run("borrow and merge", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), pageFormat(fixedCells))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for _, k := range []int{1, 2, 3, 4} {
+			r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+		assert.Equal(1, r)
+
+		// borrow from the right sibling
+		r, err = b.Delete(r, values{1})
+		assert.NoError(err)
+		r, err = b.Delete(r, values{2})
+		assert.NoError(err)
+		assert.Equal(1, r)
+
+		p, err := b.get(pageNo(r))
+		assert.NoError(err)
+		assert.Equal(branch, p.pageType)
+		assert.Equal(pageNo(3), p.left)
+		assert.Len(p.cells, 1)
+		assert.Equal(values{uint64(4)}, p.cells[0].Key)
+		assert.Equal(pageNo(2), p.cells[0].Right)
+
+		l1, err := b.get(pageNo(3))
+		assert.NoError(err)
+		assert.Len(l1.cells, 1)
+		assert.Equal(values{uint64(3)}, l1.cells[0].Key)
+		assert.Equal(pageNo(2), l1.next)
+
+		l2, err := b.get(pageNo(2))
+		assert.NoError(err)
+		assert.Len(l2.cells, 1)
+		assert.Equal(values{uint64(4)}, l2.cells[0].Key)
+		assert.Equal(pageNo(3), l2.prev)
+
+		// merge with the right sibling and collapse into the root
+		r, err = b.Delete(r, values{3})
+		assert.NoError(err)
+		assert.Equal(1, r)
+
+		l1, err = b.get(pageNo(1))
+		assert.NoError(err)
+		assert.Equal(leaf, l1.pageType)
+		assert.Equal(pageNo(0), l1.next)
+		assert.Len(l1.cells, 1)
+		assert.Equal(values{uint64(4)}, l1.cells[0].Key)
+
+		l2, err = b.get(pageNo(2))
+		assert.NoError(err)
+		assert.Equal(free, l2.pageType)
+
+		p, err = b.get(pageNo(3))
+		assert.NoError(err)
+		assert.Equal(free, p.pageType)
+		assert.Equal(pageNo(2), p.next)
+
+		assert.Equal(pageNo(3), b.FreePageNo)
+		assert.Equal(uint32(2), b.FreePageCount)
+	})
+
+	t.Run("empty branch", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(48))
+		assert.NoError(err)
+
+		var leaves [4]*Page
+		for i := range leaves {
+			l := b.newPage()
+			l.pageType = leaf
+			assert.NoError(b.create(l))
+			leaves[i] = l
+		}
+
+		key := func(c string) values {
+			return values{c + strings.Repeat("x", 30)}
+		}
+
+		// n lost its last cell.
+		n := b.newPage()
+		n.pageType = branch
+		n.left = leaves[0].pageNo
+		assert.NoError(b.create(n))
+		assert.False(n.canLend(0))
+
+		// r underflows without either of its cells and can't merge with n.
+		r := b.newPage()
+		r.pageType = branch
+		r.left = leaves[1].pageNo
+		r.cells = append(r.cells,
+			cell{Payload: Payload{Key: key("c"), Right: leaves[2].pageNo}},
+			cell{Payload: Payload{Key: key("d"), Right: leaves[3].pageNo}},
+		)
+		assert.NoError(b.create(r))
+		assert.False(r.canLend(0))
+
+		p := b.newPage()
+		p.pageType = branch
+		p.left = n.pageNo
+		p.cells = append(p.cells, cell{Payload: Payload{Key: key("b"), Right: r.pageNo}})
+		assert.NoError(b.create(p))
+		assert.False(n.canMerge(r, &p.cells[0]))
+
+		assert.NoError(b.rebalance(p, -1, n))
+
+		n, err = b.get(n.pageNo)
+		assert.NoError(err)
+		assert.Equal(leaves[0].pageNo, n.left)
+		assert.Len(n.cells, 1)
+		assert.Equal(key("b"), n.cells[0].Key)
+		assert.Equal(leaves[1].pageNo, n.cells[0].Right)
+
+		r, err = b.get(r.pageNo)
+		assert.NoError(err)
+		assert.Equal(leaves[2].pageNo, r.left)
+		assert.Len(r.cells, 1)
+		assert.Equal(key("d"), r.cells[0].Key)
+
+		p, err = b.get(p.pageNo)
+		assert.NoError(err)
+		assert.Len(p.cells, 1)
+		assert.Equal(key("c"), p.cells[0].Key)
+
+		assert.NoError(b.Close())
+	})
+
+	t.Run("many", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for k := 1; k <= 100; k++ {
+			r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+
+		for k := 2; k <= 100; k += 2 {
+			r, err = b.Delete(r, values{k})
+			assert.NoError(err)
+		}
+
+		iter, err := b.First(r)
+		assert.NoError(err)
+		for k := 1; k <= 100; k += 2 {
+			assert.NoError(iter.Next())
+			assert.Equal(values{uint64(k)}, iter.Key)
+			assert.Equal(values{fmt.Sprint(k)}, iter.Value)
+		}
+		assert.Equal(io.EOF, iter.Next())
+
+		for k := 99; k >= 1; k -= 2 {
+			r, err = b.Delete(r, values{k})
+			assert.NoError(err)
+		}
+
+		p, err := b.get(pageNo(r))
+		assert.NoError(err)
+		assert.Equal(leaf, p.pageType)
+		assert.Len(p.cells, 0)
+	})
+}
+
+func TestBTree_Overflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
+
+	b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+	assert.NoError(t, err)
+
+	long := func(k, n int) string {
+		return strings.Repeat(fmt.Sprint(k), n)
+	}
+
+	r, err := b.CreateRoot()
+	assert.NoError(t, err)
+	for k := 1; k <= 10; k++ {
+		r, err = b.Insert(r, values{k}, values{long(k, 300)})
+		assert.NoError(t, err)
+	}
+
+	t.Run("search", func(t *testing.T) {
+		assert := assert.New(t)
+
+		v, err := b.Search(r, values{7})
+		assert.NoError(err)
+		assert.Equal([]interface{}{long(7, 300)}, v)
+	})
+
+	t.Run("iterate", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.First(r)
+		assert.NoError(err)
+		for k := 1; k <= 10; k++ {
+			assert.NoError(iter.Next())
+			assert.Equal(values{uint64(k)}, iter.Key)
+			assert.Equal(values{long(k, 300)}, iter.Value)
+		}
+		assert.Equal(io.EOF, iter.Next())
+	})
+
+	t.Run("update", func(t *testing.T) {
+		assert := assert.New(t)
+
+		n, err := b.Update(r, values{3}, values{long(3, 1000)})
+		assert.NoError(err)
+		assert.Equal(r, n)
+		v, err := b.Search(r, values{3})
+		assert.NoError(err)
+		assert.Equal([]interface{}{long(3, 1000)}, v)
+
+		n, err = b.Update(r, values{3}, values{"3"})
+		assert.NoError(err)
+		assert.Equal(r, n)
+		v, err = b.Search(r, values{3})
+		assert.NoError(err)
+		assert.Equal([]interface{}{"3"}, v)
+	})
+
+	t.Run("long key", func(t *testing.T) {
+		assert := assert.New(t)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for k := 1; k <= 20; k++ {
+			r, err = b.Insert(r, values{long(k, 100)}, values{k})
+			assert.NoError(err)
+		}
+		v, err := b.Search(r, values{long(15, 100)})
+		assert.NoError(err)
+		assert.Equal([]interface{}{uint64(15)}, v)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert := assert.New(t)
+
+		iter, err := b.Iterator(r, values{5})
+		assert.NoError(err)
+		assert.NoError(iter.Next())
+		o := iter.overflow
+		assert.NotEqual(pageNo(0), o)
+
+		r, err = b.Delete(r, values{5})
+		assert.NoError(err)
+
+		p, err := b.get(o)
+		assert.NoError(err)
+		assert.Equal(free, p.pageType)
+	})
+}
+
+func TestBTree_Slotted(t *testing.T) {
+	t.Run("dense", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(4096), CellSize(512))
+		assert.NoError(err)
+
+		// 7 fixed cells would fill a page.
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for k := 1; k <= 100; k++ {
+			n, err := b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+			assert.Equal(r, n)
+		}
+
+		assert.NoError(b.Close())
+	})
+
+	t.Run("fixed cells", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		name := filepath.Join(dir, "test.db")
+		b, err := Create(name, PageSize(128), CellSize(32), pageFormat(fixedCells))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for k := 1; k <= 10; k++ {
+			r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+		assert.NoError(b.UpdateRoot(r))
+		assert.NoError(b.Close())
+
+		b, err = Open(name)
+		assert.NoError(err)
+		assert.Equal(fixedCells, b.Format)
+
+		for k := 11; k <= 20; k++ {
+			r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+		for k := 1; k <= 20; k++ {
+			v, err := b.Search(r, values{k})
+			assert.NoError(err)
+			assert.Equal([]interface{}{fmt.Sprint(k)}, v)
+		}
+
+		assert.NoError(b.Close())
+	})
+
+	t.Run("grow", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(48))
+		assert.NoError(err)
+
+		// fill the root until another cell doesn't fit.
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		var n int
+		for {
+			p, err := b.get(pageNo(r))
+			assert.NoError(err)
+			if p.willOverflow(&cell{Payload: Payload{Key: values{n + 1}, Value: values{fmt.Sprint(n + 1)}}}) {
+				break
+			}
+			n++
+			m, err := b.Insert(r, values{n}, values{fmt.Sprint(n)})
+			assert.NoError(err)
+			assert.Equal(r, m)
+		}
+
+		// the cell grows more than another cell and doesn't fit in the page anymore.
+		long := strings.Repeat("3", 20)
+		m, err := b.Update(r, values{3}, values{long})
+		assert.NoError(err)
+		assert.Equal(r, m)
+
+		p, err := b.get(pageNo(r))
+		assert.NoError(err)
+		assert.Equal(branch, p.pageType)
+
+		for k := 1; k <= n; k++ {
+			v, err := b.Search(r, values{k})
+			assert.NoError(err)
+			if k == 3 {
+				assert.Equal([]interface{}{long}, v)
+				continue
+			}
+			assert.Equal([]interface{}{fmt.Sprint(k)}, v)
+		}
+
+		assert.NoError(b.Close())
+	})
+
+	t.Run("random", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(256), CellSize(64))
+		assert.NoError(err)
+
+		c, err := b.CreateRoot()
+		assert.NoError(err)
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+
+		rnd := rand.New(rand.NewSource(1))
+		const n = 300
+		vs := map[int]string{}
+		for _, k := range rnd.Perm(n) {
+			vs[k] = strings.Repeat("x", rnd.Intn(100))
+			r, err = b.Insert(r, values{k}, values{vs[k]})
+			assert.NoError(err)
+		}
+		for _, k := range rnd.Perm(n)[:200] {
+			r, err = b.Delete(r, values{k})
+			assert.NoError(err)
+			delete(vs, k)
+		}
+
+		c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(c))
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Empty(report.Problems)
+
+		for k, v := range vs {
+			w, err := b.Search(r, values{k})
+			assert.NoError(err)
+			assert.Equal([]interface{}{v}, w)
+		}
+
+		assert.NoError(b.Close())
+	})
+}
+
+func TestBTree_Random(t *testing.T) {
+	for name, f := range map[string]keyFormat{
+		"compressed keys": compressedKeys,
+		"ordered keys":    orderedKeys,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			dir, err := ioutil.TempDir("", "test")
+			assert.NoError(err)
+			defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+			b, err := Create(filepath.Join(dir, "test.db"), PageSize(256), CellSize(64), keyEncoding(f))
+			assert.NoError(err)
+
+			c, err := b.CreateRoot()
+			assert.NoError(err)
+			r, err := b.CreateRoot()
+			assert.NoError(err)
+			c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+			assert.NoError(err)
+			assert.NoError(b.UpdateRoot(c))
+
+			// keys of various lengths share prefixes so that cells and separators vary in size.
+			key := func(k int) values {
+				return values{strings.Repeat("k", k%17), k}
+			}
+
+			rnd := rand.New(rand.NewSource(1))
+			const n = 500
+			vs := map[int]string{}
+			for i := 0; i < 5000; i++ {
+				k := rnd.Intn(n)
+				v := strings.Repeat("x", rnd.Intn(100))
+				_, ok := vs[k]
+				switch {
+				case !ok:
+					r, err = b.Insert(r, key(k), values{v})
+					vs[k] = v
+				case rnd.Intn(2) == 0:
+					r, err = b.Update(r, key(k), values{v})
+					vs[k] = v
+				default:
+					r, err = b.Delete(r, key(k))
+					delete(vs, k)
+				}
+				if !assert.NoError(err) {
+					return
+				}
+			}
+
+			report, err := b.Check()
+			assert.NoError(err)
+			assert.Empty(report.Problems)
+
+			for k, v := range vs {
+				w, err := b.Search(r, key(k))
+				assert.NoError(err)
+				assert.Equal([]interface{}{v}, w)
+			}
+
+			for _, k := range rnd.Perm(n) {
+				if _, ok := vs[k]; !ok {
+					continue
+				}
+				r, err = b.Delete(r, key(k))
+				if !assert.NoError(err) {
+					return
+				}
+			}
+
+			p, err := b.get(pageNo(r))
+			assert.NoError(err)
+			assert.Equal(leaf, p.pageType)
+			assert.Empty(p.cells)
+
+			report, err = b.Check()
+			assert.NoError(err)
+			assert.Empty(report.Problems)
+
+			assert.NoError(b.Close())
+		})
+	}
+}
+
+func TestBTree_NotComparable(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+	assert.NoError(err)
+
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	for k := 1; k <= 10; k++ {
+		r, err = b.Insert(r, values{"table", fmt.Sprint(k)}, values{k})
+		assert.NoError(err)
+	}
+	for k := 1; k <= 10; k++ {
+		v, err := b.Search(r, values{"table", fmt.Sprint(k)})
+		assert.NoError(err)
+		assert.Equal([]interface{}{uint64(k)}, v)
+	}
+
+	var e *ErrNotComparable
+
+	_, err = b.Insert(r, values{"table", float32(1)}, values{0})
+	assert.True(xerrors.As(err, &e))
+
+	_, err = b.Search(r, values{"table", struct{}{}})
+	assert.True(xerrors.As(err, &e))
+
+	_, err = b.Update(r, values{"table", float32(1)}, values{0})
+	assert.True(xerrors.As(err, &e))
+
+	_, err = b.Delete(r, values{float32(1), "1"})
+	assert.True(xerrors.As(err, &e))
+
+	_, err = b.Range(r, Bound{}, Bound{Key: []interface{}{float32(1)}})
+	assert.True(xerrors.As(err, &e))
+
+	// keys of different lengths and types are ordered.
+	r, err = b.Insert(r, values{"table"}, values{0})
+	assert.NoError(err)
+	r, err = b.Insert(r, values{"table", 1}, values{0})
+	assert.NoError(err)
+	iter, err := b.First(r)
+	assert.NoError(err)
+	assert.NoError(iter.Next())
+	assert.Equal(values{"table"}, iter.Key)
+	assert.NoError(iter.Next())
+	assert.Equal(values{"table", uint64(1)}, iter.Key)
+	assert.NoError(iter.Next())
+	assert.Equal(values{"table", "1"}, iter.Key)
+
+	assert.NoError(b.Close())
+}
+
+func TestBTree_Types(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(256), CellSize(96))
+	assert.NoError(err)
+
+	at := time.Date(2020, 1, 2, 3, 4, 5, 6, time.FixedZone("JST", 9*60*60))
+	// times a nanosecond apart don't collide and the zero time is a key as well.
+	all := values{nil, false, true, -1, 1, 1.5, "a", []byte("b"), at, at.Add(time.Nanosecond), time.Time{}}
+
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	for i, v := range all {
+		r, err = b.Insert(r, values{v}, values{v, i})
+		assert.NoError(err)
+	}
+	assert.NoError(b.UpdateRoot(r))
+	assert.NoError(b.Close())
+
+	b, err = Open(name)
+	assert.NoError(err)
+	defer func() { assert.NoError(b.Close()) }()
+
+	// ordered by types: NULL < integers < floats < strings < bytes < booleans < times.
+	iter, err := b.First(b.Root())
+	assert.NoError(err)
+	for _, i := range []int{0, 3, 4, 5, 6, 7, 1, 2, 10, 8, 9} {
+		assert.NoError(iter.Next())
+		v := iter.Value[0]
+		switch i {
+		case 8, 9:
+			// times are decoded in UTC.
+			assert.Equal(time.Date(2020, 1, 1, 18, 4, 5, 6+i-8, time.UTC), iter.Key[0])
+			assert.Equal(iter.Key[0], v)
+		case 10:
+			assert.Equal(time.Time{}, iter.Key[0])
+			assert.Equal(time.Time{}, v)
+		case 3:
+			assert.Equal(int64(-1), v)
+		case 4:
+			assert.Equal(uint64(1), v)
+		default:
+			assert.Equal(all[i], v)
+		}
+		assert.Equal(uint64(i), iter.Value[1])
+	}
+	assert.Equal(io.EOF, iter.Next())
+
+	for i, v := range all {
+		w, err := b.Search(b.Root(), values{v})
+		assert.NoError(err)
+		assert.Equal(uint64(i), w[1])
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(values{"b"}, separator(values{"apple"}, values{"banana"}))
+	assert.Equal(values{"user-01"}, separator(values{"user-0099"}, values{"user-0100"}))
+	assert.Equal(values{"ab"}, separator(values{"a"}, values{"abc"}))
+	assert.Equal(values{[]byte{0x01, 0x03}}, separator(values{[]byte{0x01, 0x02, 0x03}}, values{[]byte{0x01, 0x03, 0x04}}))
+	assert.Equal(values{2}, separator(values{1, "x"}, values{2, "y"}))
+	assert.Equal(values{1, "y"}, separator(values{1, "x"}, values{1, "yz"}))
+	assert.Equal(values{"a", nil}, separator(values{"a"}, values{"a", 1}))
+	assert.Equal(values{""}, separator(values{1}, values{"x"}))
+}
+
+func TestBTree_LongKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	b, err := Create(filepath.Join(dir, "test.db"), PageSize(512), CellSize(128))
+	assert.NoError(err)
+
+	key := func(i int) values {
+		return values{fmt.Sprintf("https://example.com/users/%04d/profile", i)}
+	}
+
+	c, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	const n = 500
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		r, err = b.Insert(r, key(2*i), values{i})
+		assert.NoError(err)
+	}
+	c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(c))
+
+	report, err := b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+
+	// separators are shorter than the keys.
+	p, err := b.get(pageNo(r))
+	assert.NoError(err)
+	assert.Equal(branch, p.pageType)
+	for _, c := range p.cells {
+		assert.True(len(c.Key[0].(string)) < len(key(0)[0].(string)), "%#v", c.Key)
+	}
+
+	// both existing and missing keys are found in the same position.
+	for i := 0; i < 2*n-1; i++ {
+		iter, err := b.Iterator(r, key(i))
+		assert.NoError(err)
+		assert.NoError(iter.Next())
+		assert.Equal(key(i+i%2), iter.Key)
+
+		iter, err = b.ReverseIterator(r, key(i))
+		assert.NoError(err)
+		assert.NoError(iter.Prev())
+		assert.Equal(key(i-i%2), iter.Key)
+
+		v, err := b.Search(r, key(i))
+		if i%2 == 1 {
+			assert.Equal(ErrNotFound, err)
+			continue
+		}
+		assert.NoError(err)
+		assert.Equal([]interface{}{uint64(i / 2)}, v)
+	}
+
+	iter, err := b.Range(r, Bound{Key: key(99)}, Bound{Key: key(200), Exclusive: true})
+	assert.NoError(err)
+	for i := 100; i < 200; i += 2 {
+		assert.NoError(iter.Next())
+		assert.Equal(key(i), iter.Key)
+	}
+	assert.Equal(io.EOF, iter.Next())
+
+	// deleting keeps the separators valid.
+	for i := 0; i < n; i += 3 {
+		r, err = b.Delete(r, key(2*i))
+		assert.NoError(err)
+	}
+	c, err = b.Update(c, values{"table", "foo"}, values{r, "create table foo"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(c))
+	report, err = b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+
+	assert.NoError(b.Close())
+}
+
+func TestBTree_InsertAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
+
+	// insert inserts the keys into a tree of a file of another tree and returns the utilization of the tree.
+	insert := func(t *testing.T, name string, keys []int, appending bool) float64 {
+		assert := assert.New(t)
+
+		b, err := Create(filepath.Join(dir, name), PageSize(256), CellSize(32))
+		assert.NoError(err)
+		defer func() { assert.NoError(b.Close()) }()
+
+		c, err := b.CreateRoot()
+		assert.NoError(err)
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		o, err := b.CreateRoot()
+		assert.NoError(err)
+		for _, k := range keys {
+			if appending {
+				r, err = b.InsertAppend(r, values{k}, values{"foo"})
+			} else {
+				r, err = b.Insert(r, values{k}, values{"foo"})
+			}
+			assert.NoError(err)
+			o, err = b.Insert(o, values{k}, values{"bar"})
+			assert.NoError(err)
+		}
+		c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+		assert.NoError(err)
+		c, err = b.Insert(c, values{"table", "bar"}, values{o, "create table bar"})
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(c))
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Empty(report.Problems)
+
+		iter, err := b.First(r)
+		assert.NoError(err)
+		for i := 0; i < len(keys); i++ {
+			assert.NoError(iter.Next())
+		}
+		assert.Equal(io.EOF, iter.Next())
+
+		// the other tree of the same keys is split in the middle.
+		u, err := b.Utilization(o)
+		assert.NoError(err)
+		assert.True(u < 0.9, "utilization of the other: %f", u)
+
+		u, err = b.Utilization(r)
+		assert.NoError(err)
+		return u
+	}
+
+	increasing := make([]int, 1000)
+	for i := range increasing {
+		increasing[i] = i
+	}
+
+	t.Run("increasing", func(t *testing.T) {
+		assert := assert.New(t)
+
+		u := insert(t, "increasing.db", increasing, true)
+		assert.True(u > 0.9, "utilization: %f", u)
+	})
+
+	t.Run("insert", func(t *testing.T) {
+		assert := assert.New(t)
+
+		u := insert(t, "insert.db", increasing, false)
+		assert.True(u < 0.6, "utilization: %f", u)
+	})
+
+	t.Run("random", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// inserts in the middle are split in the middle.
+		u := insert(t, "random.db", rand.New(rand.NewSource(1)).Perm(1000), true)
+		assert.True(u > 0.5 && u < 0.9, "utilization: %f", u)
+	})
+}
+
+// syncLog is a storage in memory which logs the offsets of writes and syncs.
+type syncLog struct {
+	Memory
+	ops []string
+}
+
+func (s *syncLog) WriteAt(p []byte, off int64) (int, error) {
+	s.ops = append(s.ops, fmt.Sprintf("write %d", off))
+	return s.Memory.WriteAt(p, off)
+}
+
+func (s *syncLog) Sync() error {
+	s.ops = append(s.ops, "sync")
+	return nil
+}
+
+func TestBTree_Durability(t *testing.T) {
+	t.Run("storage", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy       SyncPolicy
+			create, root []string
+		}{
+			{
+				policy: SyncNone,
+				create: []string{"write 0"},
+				root:   []string{"write 256", "write 0"},
+			},
+			{
+				policy: SyncOnCommit,
+				create: []string{"write 0", "sync"},
+				root:   []string{"write 256", "sync", "write 0", "sync"},
+			},
+			{
+				policy: SyncAlways,
+				create: []string{"write 0", "sync"},
+				root:   []string{"write 256", "sync", "write 0", "sync"},
+			},
+		} {
+			assert := assert.New(t)
+
+			var s syncLog
+			b, err := CreateStorage(&s, PageSize(256), CellSize(32), Durability(tc.policy))
+			assert.NoError(err)
+			assert.Equal(tc.create, s.ops)
+
+			s.ops = nil
+			_, err = b.CreateRoot()
+			assert.NoError(err)
+			assert.Equal(tc.root, s.ops)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
+
+		for _, p := range []SyncPolicy{SyncNone, SyncOnCommit, SyncAlways} {
+			assert := assert.New(t)
+
+			name := filepath.Join(dir, fmt.Sprintf("%d.db", p))
+			b, err := Create(name, PageSize(256), CellSize(32), Durability(p))
+			assert.NoError(err)
+			assert.Equal(p == SyncNone, b.wal.noSync)
+			r, err := b.CreateRoot()
+			assert.NoError(err)
+			for i := 0; i < 100; i++ {
+				r, err = b.Insert(r, values{i}, values{"foo"})
+				assert.NoError(err)
+			}
+			assert.NoError(b.UpdateRoot(r))
+			assert.NoError(b.Close())
+
+			b, err = Open(name, Durability(p))
+			assert.NoError(err)
+			v, err := b.Search(b.Root(), values{99})
+			assert.NoError(err)
+			assert.Equal([]interface{}{"foo"}, v)
+			assert.NoError(b.Close())
+		}
+	})
+}
+
+// failure makes writes fail while fail is set. the first writes of them succeed as many as writes.
+type failure struct {
+	fail   bool
+	writes int
+}
+
+func (f *failure) write() error {
+	if !f.fail {
+		return nil
+	}
+	if f.writes == 0 {
+		return xerrors.New("failed to write")
+	}
+	f.writes--
+	return nil
+}
+
+// failingWrites is a storage in memory whose writes fail.
+type failingWrites struct {
+	Memory
+	failure
+}
+
+func (f *failingWrites) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.write(); err != nil {
+		return 0, err
+	}
+	return f.Memory.WriteAt(p, off)
+}
+
+// failingFile is a file whose writes fail.
+type failingFile struct {
+	*fileStorage
+	failure
+}
+
+func (f *failingFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.write(); err != nil {
+		return 0, err
+	}
+	return f.fileStorage.WriteAt(p, off)
+}
+
+func TestBTree_FailedCommit(t *testing.T) {
+	t.Run("no log", func(t *testing.T) {
+		for _, writes := range []int{0, 1, 2} {
+			t.Run(fmt.Sprint(writes), func(t *testing.T) {
+				assert := assert.New(t)
+
+				var s failingWrites
+				b, err := CreateStorage(&s, PageSize(128), CellSize(32))
+				assert.NoError(err)
+				r, err := b.CreateRoot()
+				assert.NoError(err)
+				assert.NoError(b.UpdateRoot(r))
+				for k := 1; k <= 5; k++ {
+					r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+					assert.NoError(err)
+				}
+
+				// the file may have some of the pages written so the tree can't be used anymore.
+				s.failure = failure{fail: true, writes: writes}
+				tx, err := b.Begin()
+				assert.NoError(err)
+				for k := 6; k <= 20; k++ {
+					r, err = tx.Insert(r, values{k}, values{fmt.Sprint(k)})
+					assert.NoError(err)
+				}
+				assert.True(xerrors.Is(tx.Commit(), ErrBroken))
+				s.failure = failure{}
+
+				_, err = b.Search(r, values{1})
+				assert.True(xerrors.Is(err, ErrBroken))
+				_, err = b.Insert(r, values{21}, values{"21"})
+				assert.True(xerrors.Is(err, ErrBroken))
+				assert.True(xerrors.Is(b.Flush(), ErrBroken))
+				assert.NoError(b.Close())
+			})
+		}
+	})
+
+	t.Run("log", func(t *testing.T) {
+		for _, writes := range []int{0, 1, 2} {
+			t.Run(fmt.Sprint(writes), func(t *testing.T) {
+				assert := assert.New(t)
+
+				dir, err := ioutil.TempDir("", "test")
+				assert.NoError(err)
+				defer func() {
+					assert.NoError(os.RemoveAll(dir))
+				}()
+
+				name := filepath.Join(dir, "test.db")
+				b, err := Create(name, PageSize(128), CellSize(32))
+				assert.NoError(err)
+				f := failingFile{fileStorage: b.file.(*fileStorage)}
+				b.file = &f
+				r, err := b.CreateRoot()
+				assert.NoError(err)
+				assert.NoError(b.UpdateRoot(r))
+				for k := 1; k <= 5; k++ {
+					r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+					assert.NoError(err)
+				}
+
+				// the commit is done once it's in the log even if some of the pages fail to be written.
+				f.failure = failure{fail: true, writes: writes}
+				tx, err := b.Begin()
+				assert.NoError(err)
+				for k := 6; k <= 20; k++ {
+					r, err = tx.Insert(r, values{k}, values{fmt.Sprint(k)})
+					assert.NoError(err)
+				}
+				assert.NoError(tx.UpdateRoot(r))
+				assert.NoError(tx.Commit())
+				v, err := b.Search(r, values{20})
+				assert.NoError(err)
+				assert.Equal([]interface{}{"20"}, v)
+
+				// the log isn't discarded until the pages are written.
+				assert.Error(b.Close())
+				assert.NotEqual(int64(0), b.wal.size)
+
+				f.failure = failure{}
+				assert.NoError(b.Close())
+
+				b, err = Open(name)
+				assert.NoError(err)
+				iter, err := b.First(b.Root())
+				assert.NoError(err)
+				for k := 1; k <= 20; k++ {
+					assert.NoError(iter.Next())
+					assert.Equal(values{uint64(k)}, iter.Key)
+				}
+				assert.Equal(io.EOF, iter.Next())
+				assert.NoError(b.Close())
+			})
+		}
+	})
+}
+
+func TestBTree_FreeList(t *testing.T) {
+	t.Run("reuse", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		name := filepath.Join(dir, "test.db")
+		b, err := Create(name, PageSize(128), CellSize(32))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for k := 1; k <= 30; k++ {
+			r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+		fi, err := os.Stat(name)
+		assert.NoError(err)
+		size := fi.Size()
+
+		for k := 1; k <= 30; k++ {
+			r, err = b.Delete(r, values{k})
+			assert.NoError(err)
+		}
+		assert.NotEqual(pageNo(0), b.FreePageNo)
+		assert.Equal(uint32(size/128-2), b.FreePageCount)
+		assert.NoError(b.Close())
+
+		b, err = Open(name)
+		assert.NoError(err)
+		assert.Equal(uint32(size/128-2), b.FreePageCount)
+
+		for k := 1; k <= 30; k++ {
+			r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+		fi, err = os.Stat(name)
+		assert.NoError(err)
+		assert.Equal(size, fi.Size())
+		assert.NoError(b.Close())
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for k := 1; k <= 10; k++ {
+			r, err = b.Insert(r, values{k}, values{strings.Repeat("x", 100)})
+			assert.NoError(err)
+		}
+
+		assert.NoError(b.Drop(r))
+
+		var n uint32
+		for f := b.FreePageNo; f != 0; n++ {
+			p, err := b.get(f)
+			assert.NoError(err)
+			assert.Equal(free, p.pageType)
+			f = p.next
+		}
+		assert.Equal(n, b.FreePageCount)
+
+		r, err = b.CreateRoot()
+		assert.NoError(err)
+		assert.Equal(n-1, b.FreePageCount)
+	})
+}
+
+func TestBTree_Flush(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(128), CellSize(32), CacheSize(4))
+	assert.NoError(err)
+
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	for k := 1; k <= 30; k++ {
+		r, err = b.Insert(r, values{k}, values{fmt.Sprint(k)})
+		assert.NoError(err)
+	}
+	assert.NoError(b.Flush())
+
+	// read the file directly bypassing the cache.
+	f, err := os.Open(name)
+	assert.NoError(err)
+	o := BTree{header: b.header, file: &fileStorage{File: f}, cache: newCache(0)}
+	iter, err := o.First(r)
+	assert.NoError(err)
+	for k := 1; k <= 30; k++ {
+		assert.NoError(iter.Next())
+		assert.Equal(values{uint64(k)}, iter.Key)
+		assert.Equal(values{fmt.Sprint(k)}, iter.Value)
+	}
+	assert.Equal(io.EOF, iter.Next())
+	assert.NoError(f.Close())
+	assert.NoError(b.Close())
+}
+
+func TestOpen_Recover(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(128), CellSize(32))
+	assert.NoError(err)
+
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err = b.Insert(r, values{1}, values{"1"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(r))
+
+	// log a batch of pages and crash before writing them to the file.
+	l := b.newPage()
+	l.pageType = leaf
+	l.cells = append(l.cells,
+		cell{Payload: Payload{Key: values{1}, Value: values{"1"}}},
+		cell{Payload: Payload{Key: values{2}, Value: values{"2"}}},
+	)
+	var buf bytes.Buffer
+	_, err = l.WriteTo(&buf)
+	assert.NoError(err)
+	h := b.header
+	h.RootPageNo = 0
+	var hbuf bytes.Buffer
+	_, err = h.WriteTo(&hbuf)
+	assert.NoError(err)
+	assert.NoError(b.wal.append([]frame{
+		{pageNo: pageNo(r), data: buf.Bytes()},
+		{pageNo: 0, data: hbuf.Bytes()},
+	}))
+	assert.NoError(b.file.(*fileStorage).Close())
+	assert.NoError(b.wal.file.Close())
+
+	b, err = Open(name)
+	assert.NoError(err)
+	assert.Equal(0, b.Root())
+
+	v, err := b.Search(r, values{2})
+	assert.NoError(err)
+	assert.Equal([]interface{}{"2"}, v)
+
+	fi, err := os.Stat(name + walSuffix)
+	assert.NoError(err)
+	assert.Equal(int64(0), fi.Size())
+
+	assert.NoError(b.Close())
+}
+
+func TestOpen_ReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	_, err = Create(name, ReadOnly(true))
+	assert.Equal(ErrReadOnly, err)
+	_, err = os.Stat(name)
+	assert.True(os.IsNotExist(err))
+
+	b, err := Create(name, PageSize(128), CellSize(32))
+	assert.NoError(err)
+
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err = b.Insert(r, values{1}, values{"1"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(r))
+
+	// log a new root beyond the end of the file and crash before writing it.
+	l := b.newPage()
+	l.pageNo = b.pages
+	l.pageType = leaf
+	l.cells = append(l.cells,
+		cell{Payload: Payload{Key: values{1}, Value: values{"1"}}},
+		cell{Payload: Payload{Key: values{2}, Value: values{"2"}}},
+	)
+	var buf bytes.Buffer
+	_, err = l.WriteTo(&buf)
+	assert.NoError(err)
+	h := b.header
+	h.RootPageNo = l.pageNo
+	var hbuf bytes.Buffer
+	_, err = h.WriteTo(&hbuf)
+	assert.NoError(err)
+	assert.NoError(b.wal.append([]frame{
+		{pageNo: l.pageNo, data: buf.Bytes()},
+		{pageNo: 0, data: hbuf.Bytes()},
+	}))
+	assert.NoError(b.file.(*fileStorage).Close())
+	assert.NoError(b.wal.file.Close())
+	fi, err := os.Stat(name)
+	assert.NoError(err)
+	size := fi.Size()
+	wi, err := os.Stat(name + walSuffix)
+	assert.NoError(err)
+
+	b, err = Open(name, ReadOnly(true))
+	assert.NoError(err)
+
+	// the committed pages in the log are seen without being written.
+	assert.Equal(int(l.pageNo), b.Root())
+	v, err := b.Search(b.Root(), values{2})
+	assert.NoError(err)
+	assert.Equal([]interface{}{"2"}, v)
+	v, err = b.Search(r, values{1})
+	assert.NoError(err)
+	assert.Equal([]interface{}{"1"}, v)
+
+	_, err = b.CreateRoot()
+	assert.Equal(ErrReadOnly, err)
+	_, err = b.Insert(b.Root(), values{3}, values{"3"})
+	assert.Equal(ErrReadOnly, err)
+	_, err = b.Update(b.Root(), values{1}, values{"one"})
+	assert.Equal(ErrReadOnly, err)
+	_, err = b.Delete(b.Root(), values{1})
+	assert.Equal(ErrReadOnly, err)
+	assert.Equal(ErrReadOnly, b.UpdateRoot(r))
+	assert.Equal(ErrReadOnly, b.Drop(r))
+	assert.Equal(ErrReadOnly, b.Vacuum())
+	assert.NoError(b.Flush())
+	assert.NoError(b.Close())
+
+	fi, err = os.Stat(name)
+	assert.NoError(err)
+	assert.Equal(size, fi.Size())
+	fi, err = os.Stat(name + walSuffix)
+	assert.NoError(err)
+	assert.Equal(wi.Size(), fi.Size())
+
+	// a writable open recovers them as usual.
+	b, err = Open(name)
+	assert.NoError(err)
+	defer func() { assert.NoError(b.Close()) }()
+	v, err = b.Search(b.Root(), values{2})
+	assert.NoError(err)
+	assert.Equal([]interface{}{"2"}, v)
+}
+
+func TestOpen_Corrupt(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(128), CellSize(32))
+	assert.NoError(err)
+
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err = b.Insert(r, values{1}, values{"1"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(r))
+	assert.NoError(b.Close())
+
+	// flip a bit in the payload of the first cell.
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	assert.NoError(err)
+	bs := make([]byte, 1)
+	off := int64(r)*128 + pageHeaderSize + cellHeaderSize
+	_, err = f.ReadAt(bs, off)
+	assert.NoError(err)
+	bs[0] ^= 0x01
+	_, err = f.WriteAt(bs, off)
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	b, err = Open(name)
+	assert.NoError(err)
+
+	_, err = b.Search(r, values{1})
+	var e *ErrCorruptPage
+	assert.True(xerrors.As(err, &e))
+	assert.Equal(r, e.PageNo)
+
+	assert.NoError(b.Close())
+}
+
+func TestBTree_Transaction(t *testing.T) {
+	t.Run("commit", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		name := filepath.Join(dir, "test.db")
+		b, err := Create(name, PageSize(128), CellSize(32))
+		assert.NoError(err)
+
+		tx, err := b.Begin()
+		assert.NoError(err)
+		r, err := tx.CreateRoot()
+		assert.NoError(err)
+		for k := 1; k <= 10; k++ {
+			r, err = tx.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+		assert.NoError(tx.UpdateRoot(r))
+		v, err := tx.Search(tx.Root(), values{7})
+		assert.NoError(err)
+		assert.Equal([]interface{}{"7"}, v)
+
+		// nothing is written until commit.
+		assert.Equal(int64(0), b.wal.size)
+		fi, err := os.Stat(name)
+		assert.NoError(err)
+		assert.Equal(int64(128), fi.Size())
+
+		assert.NoError(tx.Commit())
+		assert.Equal(ErrNoTransaction, tx.Commit())
+		_, err = tx.Insert(r, values{11}, values{"11"})
+		assert.Equal(ErrNoTransaction, err)
+		assert.NoError(b.Close())
+
+		b, err = Open(name)
+		assert.NoError(err)
+		assert.Equal(r, b.Root())
+		v, err = b.Search(b.Root(), values{7})
+		assert.NoError(err)
+		assert.Equal([]interface{}{"7"}, v)
+		assert.NoError(b.Close())
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		assert.NoError(err)
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		r, err = b.Insert(r, values{1}, values{"1"})
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(r))
+
+		tx, err := b.Begin()
+		assert.NoError(err)
+		n := r
+		for k := 2; k <= 10; k++ {
+			n, err = tx.Insert(n, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+		}
+		assert.NoError(tx.UpdateRoot(n))
+		assert.NoError(tx.Rollback())
+		assert.Equal(ErrNoTransaction, tx.Rollback())
+
+		assert.Equal(r, b.Root())
+		assert.Equal(pageNo(2), b.pages)
+
+		iter, err := b.First(b.Root())
+		assert.NoError(err)
+		assert.NoError(iter.Next())
+		assert.Equal(values{uint64(1)}, iter.Key)
+		assert.Equal(io.EOF, iter.Next())
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+		defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32))
+		assert.NoError(err)
+		defer func() { assert.NoError(b.Close()) }()
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(r))
+
+		tx, err := b.Begin()
+		assert.NoError(err)
+		_, err = tx.Insert(r, values{1}, values{"1"})
+		assert.NoError(err)
+
+		// the others wait for the transaction instead of seeing or joining it.
+		read := make(chan error)
+		go func() {
+			_, err := b.Search(r, values{1})
+			read <- err
+		}()
+		write := make(chan error)
+		go func() {
+			_, err := b.Insert(r, values{2}, values{"2"})
+			write <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case <-read:
+			assert.Fail("read during transaction")
+		case <-write:
+			assert.Fail("wrote during transaction")
+		default:
+		}
+
+		// the rollback discards the changes of the transaction only.
+		assert.NoError(tx.Rollback())
+		assert.NoError(<-write)
+		assert.Equal(ErrNotFound, <-read)
+
+		_, err = b.Search(r, values{1})
+		assert.Equal(ErrNotFound, err)
+		v, err := b.Search(r, values{2})
+		assert.NoError(err)
+		assert.Equal([]interface{}{"2"}, v)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, err := CreateStorage(&Memory{}, PageSize(128), CellSize(32))
+		assert.NoError(err)
+		b.readOnly = true
+
+		_, err = b.Begin()
+		assert.Equal(ErrReadOnly, err)
+		assert.NoError(b.Close())
+	})
+}
+
+func TestBTree_Concurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	b, err := Create(filepath.Join(dir, "test.db"), PageSize(128), CellSize(32), CacheSize(8))
+	assert.NoError(err)
+
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(r))
+
+	const n = 200
+	var max int64 // the greatest key visible from the root
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := b.Root()
+		for k := 1; k <= n; k++ {
+			root, err := b.Insert(r, values{k}, values{fmt.Sprint(k)})
+			assert.NoError(err)
+			assert.Equal(r, root) // the root keeps its page number even if it splits.
+			atomic.StoreInt64(&max, int64(k))
+		}
+	}()
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				m := int(atomic.LoadInt64(&max))
+				if m == 0 {
+					continue
+				}
+
+				k := m - i%m
+				v, err := b.Search(b.Root(), values{k})
+				assert.NoError(err)
+				assert.Equal([]interface{}{fmt.Sprint(k)}, v)
+
+				iter, err := b.First(b.Root())
+				assert.NoError(err)
+				c := 0
+				for iter.Next() == nil {
+					c++
+				}
+				assert.True(c >= m)
+
+				if m == n {
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	assert.NoError(b.Close())
+}
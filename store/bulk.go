@@ -0,0 +1,223 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+var ErrNotSorted = xerrors.New("keys not sorted")
+
+// Pairs is a source of key-value pairs sorted by keys. Next returns io.EOF after the last pair.
+type Pairs interface {
+	Next() (key, value []interface{}, err error)
+}
+
+// BulkLoad builds a new tree from the sorted pairs and returns its root. instead of inserting them one by one, it
+// writes leaves sequentially filling each up to fillFactor (0 < fillFactor <= 1) of the page and then builds the
+// branches on top of them level by level. keys must be strictly increasing. the other writers wait until it's done
+// while readers don't wait for pairs so that pairs can read the tree, e.g. by an Iterator, but can't write to it.
+func (b *BTree) BulkLoad(ctx context.Context, pairs Pairs, fillFactor float64) (_ int, err error) {
+	if fillFactor <= 0 || fillFactor > 1 {
+		return 0, xerrors.Errorf("invalid fill factor: %f", fillFactor)
+	}
+
+	b.lock()
+	defer b.unlock()
+	if b.readOnly {
+		return 0, ErrReadOnly
+	}
+	defer b.autocommit(b.snapshot(), &err)
+
+	l := loader{btree: b, fill: fillFactor}
+	left, cells, err := l.leaves(ctx, pairs)
+	if err != nil {
+		return 0, err
+	}
+	for len(cells) > 0 {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		left, cells, err = l.branches(left, cells)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(left), nil
+}
+
+// loader builds a tree bottom-up.
+type loader struct {
+	btree *BTree
+	fill  float64
+	used  int // uncompressed size of the page being filled
+}
+
+// leaves writes the pairs into linked leaves and returns the first leaf and the separators of the rest.
+func (l *loader) leaves(ctx context.Context, pairs Pairs) (pageNo, []cell, error) {
+	b := l.btree
+
+	p := b.newPage()
+	p.pageType = leaf
+	if err := b.create(p); err != nil {
+		return 0, nil, xerrors.Errorf("failed to create leaf: %w", err)
+	}
+	first := p.pageNo
+
+	var (
+		seps []cell
+		last []byte
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, nil, err
+		}
+		key, val, err := l.next(pairs)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, nil, xerrors.Errorf("failed to get next pair: %w", err)
+		}
+		c := cell{Payload: Payload{Key: key, Value: val}}
+		k, err := c.sortKey()
+		if err != nil {
+			return 0, nil, err
+		}
+		if last != nil {
+			switch d := bytes.Compare(last, k); {
+			case d == 0:
+				return 0, nil, ErrDuplicateKey
+			case d > 0:
+				return 0, nil, xerrors.Errorf("%#v after %#v: %w", values(key), values(p.cells[len(p.cells)-1].Key), ErrNotSorted)
+			}
+		}
+		last = k
+
+		if l.add(p, &c) {
+			continue
+		}
+		r := b.newPage()
+		r.pageType = leaf
+		r.prev = p.pageNo
+		if err := b.create(r); err != nil {
+			return 0, nil, xerrors.Errorf("failed to create leaf: %w", err)
+		}
+		p.next = r.pageNo
+		if err := b.update(p); err != nil {
+			return 0, nil, xerrors.Errorf("failed to update leaf: %w", err)
+		}
+		seps = append(seps, cell{Payload: Payload{Key: separator(p.cells[len(p.cells)-1].Key, key), Right: r.pageNo}})
+		p = r
+		l.add(p, &c)
+	}
+	if err := b.update(p); err != nil {
+		return 0, nil, xerrors.Errorf("failed to update leaf: %w", err)
+	}
+	return first, seps, nil
+}
+
+// next gets the next pair letting readers in meanwhile. the pages written so far are unreachable from any root and
+// the other writers still wait.
+func (l *loader) next(pairs Pairs) ([]interface{}, []interface{}, error) {
+	l.btree.mu.Unlock()
+	defer l.btree.mu.Lock()
+	return pairs.Next()
+}
+
+// branches writes the branch pages over the leftmost child left and the children of the cells. it returns the first
+// branch and the separators of the rest which make the level above.
+func (l *loader) branches(left pageNo, cells []cell) (pageNo, []cell, error) {
+	b := l.btree
+
+	var (
+		first pageNo
+		prev  *Page
+		seps  []cell
+		sep   *cell // separator of p in the level above
+	)
+	p := b.newPage()
+	p.pageType = branch
+	p.left = left
+	finish := func() error {
+		if err := b.create(p); err != nil {
+			return xerrors.Errorf("failed to create branch: %w", err)
+		}
+		if first == 0 {
+			first = p.pageNo
+		}
+		if sep != nil {
+			sep.setRight(p.pageNo)
+			seps = append(seps, *sep)
+		}
+		return nil
+	}
+	for i := range cells {
+		c := cells[i]
+		if l.add(p, &c) {
+			continue
+		}
+		if err := finish(); err != nil {
+			return 0, nil, err
+		}
+		prev, sep = p, &c
+		p = b.newPage()
+		p.pageType = branch
+		p.left = c.Right
+	}
+
+	if len(p.cells) == 0 && prev != nil {
+		// the last branch only has the leftmost child. give it to the previous one along with the separator or, if
+		// it doesn't fit, take the last child of the previous one.
+		c := cell{Payload: Payload{Key: sep.Key, Right: p.left}}
+		if !prev.willOverflow(&c) {
+			prev.cells = append(prev.cells, c)
+			if err := b.update(prev); err != nil {
+				return 0, nil, xerrors.Errorf("failed to update branch: %w", err)
+			}
+			return first, seps, nil
+		}
+		m := prev.cells[len(prev.cells)-1]
+		prev.cells = prev.cells[:len(prev.cells)-1]
+		if err := b.update(prev); err != nil {
+			return 0, nil, xerrors.Errorf("failed to update branch: %w", err)
+		}
+		p.left = m.Right
+		p.cells = append(p.cells, c)
+		sep = &cell{Payload: Payload{Key: m.Key}}
+	}
+	if err := finish(); err != nil {
+		return 0, nil, err
+	}
+	return first, seps, nil
+}
+
+// add appends c to p unless it makes p fuller than the fill factor and reports whether it did. an empty page always
+// takes c.
+func (l *loader) add(p *Page, c *cell) bool {
+	if len(p.cells) == 0 {
+		l.used = p.sizeOf(nil, nil)
+	}
+	if p.format == fixedCells {
+		if len(p.cells) > 0 && len(p.cells)+1 > int(l.fill*float64(p.capacity())) {
+			return false
+		}
+		p.cells = append(p.cells, *c)
+		return true
+	}
+	limit := pageHeaderSize + int(l.fill*float64(p.size-pageHeaderSize))
+	n := l.used + p.footprint(c, nil)
+	if len(p.cells) > 0 && n > limit {
+		// the keys may share a longer prefix than the cells in p. it's never larger than the uncompressed size.
+		cells := make([]cell, len(p.cells), len(p.cells)+1)
+		copy(cells, p.cells)
+		if p.usedBy(append(cells, *c)) > limit {
+			return false
+		}
+	}
+	p.cells = append(p.cells, *c)
+	l.used = n
+	return true
+}
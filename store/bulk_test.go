@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
+)
+
+func TestBTree_BulkLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
+
+	sorted := func(n int, value func(i int) []interface{}) *pairList {
+		ps := make(pairList, n)
+		for i := range ps {
+			ps[i] = [2][]interface{}{{fmt.Sprintf("key-%05d", i)}, value(i)}
+		}
+		return &ps
+	}
+	small := func(i int) []interface{} { return []interface{}{uint64(i)} }
+
+	// load loads the pairs into a new file and registers the tree in the catalog so that Check walks it.
+	load := func(t *testing.T, name string, ps Pairs, fillFactor float64, opts ...option) (*BTree, int) {
+		assert := assert.New(t)
+		b, err := Create(filepath.Join(dir, name), opts...)
+		assert.NoError(err)
+		r, err := b.BulkLoad(context.Background(), ps, fillFactor)
+		assert.NoError(err)
+		c, err := b.CreateRoot()
+		assert.NoError(err)
+		c, err = b.Insert(c, []interface{}{"table", "foo"}, []interface{}{uint64(r), "create table foo"})
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(c))
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Empty(report.Problems)
+		return b, r
+	}
+
+	// scan walks the tree both ways and returns the number of pairs.
+	scan := func(t *testing.T, b *BTree, r int, value func(i int) []interface{}) int {
+		assert := assert.New(t)
+		iter, err := b.First(r)
+		assert.NoError(err)
+		var n int
+		for ; ; n++ {
+			if err := iter.Next(); err != nil {
+				assert.Equal(io.EOF, err)
+				break
+			}
+			assert.Equal(values{fmt.Sprintf("key-%05d", n)}, iter.Key)
+			assert.Equal(values(value(n)), iter.Value)
+		}
+		iter, err = b.Last(r)
+		assert.NoError(err)
+		for i := n - 1; i >= 0; i-- {
+			assert.NoError(iter.Prev())
+			assert.Equal(values{fmt.Sprintf("key-%05d", i)}, iter.Key)
+		}
+		assert.Equal(io.EOF, iter.Prev())
+		return n
+	}
+
+	t.Run("sorted", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, r := load(t, "sorted.db", sorted(2000, small), 1, PageSize(512), CellSize(64))
+		defer func() { assert.NoError(b.Close()) }()
+		assert.Equal(2000, scan(t, b, r, small))
+
+		v, err := b.Search(r, []interface{}{"key-01234"})
+		assert.NoError(err)
+		assert.Equal(small(1234), v)
+
+		// it takes fewer pages than inserting them one by one in random order.
+		o, err := Create(filepath.Join(dir, "inserted.db"), PageSize(512), CellSize(64))
+		assert.NoError(err)
+		defer func() { assert.NoError(o.Close()) }()
+		or, err := o.CreateRoot()
+		assert.NoError(err)
+		for _, i := range rand.New(rand.NewSource(1)).Perm(2000) {
+			or, err = o.Insert(or, []interface{}{fmt.Sprintf("key-%05d", i)}, small(i))
+			assert.NoError(err)
+		}
+		assert.True(b.pages < o.pages, "%d >= %d", b.pages, o.pages)
+
+		// the tree is an ordinary tree after all.
+		for i := 0; i < 2000; i += 2 {
+			r, err = b.Delete(r, []interface{}{fmt.Sprintf("key-%05d", i)})
+			assert.NoError(err)
+		}
+		r, err = b.Insert(r, []interface{}{"key-00000"}, small(0))
+		assert.NoError(err)
+		c, err := b.Update(b.Root(), []interface{}{"table", "foo"}, []interface{}{uint64(r), "create table foo"})
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(c))
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Empty(report.Problems)
+	})
+
+	t.Run("fill factor", func(t *testing.T) {
+		assert := assert.New(t)
+
+		full, r := load(t, "full.db", sorted(1000, small), 1, PageSize(512), CellSize(64))
+		defer func() { assert.NoError(full.Close()) }()
+		assert.Equal(1000, scan(t, full, r, small))
+
+		half, r := load(t, "half.db", sorted(1000, small), 0.5, PageSize(512), CellSize(64))
+		defer func() { assert.NoError(half.Close()) }()
+		assert.Equal(1000, scan(t, half, r, small))
+
+		assert.True(full.pages < half.pages, "%d >= %d", full.pages, half.pages)
+
+		// the leaves are filled up to the fill factor but not much less.
+		p, err := half.get(pageNo(r))
+		assert.NoError(err)
+		for p.pageType == branch {
+			p, err = half.get(p.left)
+			assert.NoError(err)
+		}
+		assert.True(p.used()-pageHeaderSize <= (512-pageHeaderSize)/2)
+		assert.True(p.used()-pageHeaderSize > (512-pageHeaderSize)/3)
+	})
+
+	t.Run("fixed cells", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, r := load(t, "fixed.db", sorted(1000, small), 0.8, PageSize(512), CellSize(64), pageFormat(fixedCells))
+		defer func() { assert.NoError(b.Close()) }()
+		assert.Equal(1000, scan(t, b, r, small))
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		assert := assert.New(t)
+
+		large := func(i int) []interface{} { return []interface{}{strings.Repeat(fmt.Sprint(i%10), 200+i)} }
+		b, r := load(t, "overflow.db", sorted(300, large), 1, PageSize(512), CellSize(64))
+		defer func() { assert.NoError(b.Close()) }()
+		assert.Equal(300, scan(t, b, r, large))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, r := load(t, "empty.db", &pairList{}, 1)
+		defer func() { assert.NoError(b.Close()) }()
+		assert.Equal(0, scan(t, b, r, small))
+	})
+
+	t.Run("same tree", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, r := load(t, "same.db", sorted(1000, small), 1, PageSize(512), CellSize(64))
+		defer func() { assert.NoError(b.Close()) }()
+
+		// an index of the tree is built while iterating over the tree.
+		iter, err := b.First(r)
+		assert.NoError(err)
+		i, err := b.BulkLoad(context.Background(), &indexPairs{iter: iter}, 1)
+		assert.NoError(err)
+
+		iter, err = b.First(i)
+		assert.NoError(err)
+		for n := 0; n < 1000; n++ {
+			assert.NoError(iter.Next())
+			assert.Equal(values(small(n)), iter.Key)
+			assert.Equal(values{fmt.Sprintf("key-%05d", n)}, iter.Value)
+		}
+		assert.Equal(io.EOF, iter.Next())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, err := Create(filepath.Join(dir, "errors.db"), PageSize(512), CellSize(64))
+		assert.NoError(err)
+		defer func() { assert.NoError(b.Close()) }()
+		pages := b.pages
+
+		_, err = b.BulkLoad(context.Background(), sorted(10, small), 0)
+		assert.Error(err)
+
+		_, err = b.BulkLoad(context.Background(), &pairList{
+			{{"b"}, {1}},
+			{{"a"}, {2}},
+		}, 1)
+		assert.True(xerrors.Is(err, ErrNotSorted))
+
+		_, err = b.BulkLoad(context.Background(), &pairList{
+			{{"a"}, {1}},
+			{{"a"}, {2}},
+		}, 1)
+		assert.Equal(ErrDuplicateKey, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = b.BulkLoad(ctx, sorted(1000, small), 1)
+		assert.Equal(context.Canceled, err)
+
+		// nothing is written.
+		assert.Equal(pages, b.pages)
+	})
+}
+
+// indexPairs swaps the keys and the values of the tree the iterator walks.
+type indexPairs struct {
+	iter *Iterator
+}
+
+func (p *indexPairs) Next() ([]interface{}, []interface{}, error) {
+	if err := p.iter.Next(); err != nil {
+		return nil, nil, err
+	}
+	return p.iter.Value, p.iter.Key, nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+)
+
+// cache keeps recently used pages in memory. clean pages are evicted in LRU order while dirty pages stay until flush.
+type cache struct {
+	mu    sync.Mutex // readers of BTree share the cache.
+	size  int        // max number of clean pages
+	dirty int
+	list  *list.List // the front is the most recently used.
+	pages map[pageNo]*list.Element
+}
+
+type cacheEntry struct {
+	page  *Page
+	dirty bool
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:  size,
+		list:  list.New(),
+		pages: make(map[pageNo]*list.Element, size),
+	}
+}
+
+// get returns a copy of the cached page so that the modifications don't affect the cache until put.
+func (c *cache) get(n pageNo) (*Page, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.pages[n]
+	if !ok {
+		return nil, false
+	}
+	c.list.MoveToFront(e)
+	return e.Value.(*cacheEntry).page.clone(), true
+}
+
+// put stores a copy of the page.
+func (c *cache) put(p *Page, dirty bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.pages[p.pageNo]; ok {
+		ce := e.Value.(*cacheEntry)
+		ce.page = p.clone()
+		if dirty && !ce.dirty {
+			ce.dirty = true
+			c.dirty++
+		}
+		c.list.MoveToFront(e)
+	} else {
+		c.pages[p.pageNo] = c.list.PushFront(&cacheEntry{page: p.clone(), dirty: dirty})
+		if dirty {
+			c.dirty++
+		}
+	}
+	c.evict()
+}
+
+func (c *cache) evict() {
+	for e := c.list.Back(); e != nil && len(c.pages)-c.dirty > c.size; {
+		prev := e.Prev()
+		if ce := e.Value.(*cacheEntry); !ce.dirty {
+			c.list.Remove(e)
+			delete(c.pages, ce.page.pageNo)
+		}
+		e = prev
+	}
+}
+
+// dirtyPages returns the dirty pages in the order of page number. they stay dirty until clean.
+func (c *cache) dirtyPages() []*Page {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ps []*Page
+	for _, e := range c.pages {
+		if ce := e.Value.(*cacheEntry); ce.dirty {
+			ps = append(ps, ce.page)
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].pageNo < ps[j].pageNo
+	})
+	return ps
+}
+
+// clean marks the dirty pages clean once they're written.
+func (c *cache) clean() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.pages {
+		e.Value.(*cacheEntry).dirty = false
+	}
+	c.dirty = 0
+	c.evict()
+}
+
+// discard drops the dirty pages.
+func (c *cache) discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n, e := range c.pages {
+		if e.Value.(*cacheEntry).dirty {
+			c.list.Remove(e)
+			delete(c.pages, n)
+		}
+	}
+	c.dirty = 0
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	page := func(n pageNo) *Page {
+		p := NewPage(128, 32)
+		p.pageNo = n
+		p.pageType = leaf
+		return p
+	}
+
+	t.Run("get", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c := newCache(2)
+		c.put(page(1), false)
+
+		p, ok := c.get(1)
+		assert.True(ok)
+		assert.Equal(pageNo(1), p.pageNo)
+
+		// modifications don't affect the cache until put.
+		p.pageType = branch
+		q, ok := c.get(1)
+		assert.True(ok)
+		assert.Equal(leaf, q.pageType)
+
+		_, ok = c.get(2)
+		assert.False(ok)
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c := newCache(2)
+		c.put(page(1), false)
+		c.put(page(2), false)
+
+		_, ok := c.get(1)
+		assert.True(ok)
+
+		// 2 is the least recently used.
+		c.put(page(3), false)
+		_, ok = c.get(2)
+		assert.False(ok)
+
+		// dirty pages are not evicted.
+		c.put(page(4), true)
+		c.put(page(5), true)
+		c.put(page(6), false)
+		for _, n := range []pageNo{4, 5, 6} {
+			_, ok = c.get(n)
+			assert.True(ok)
+		}
+		_, ok = c.get(1)
+		assert.False(ok)
+	})
+
+	t.Run("clean", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c := newCache(0)
+		c.put(page(3), true)
+		c.put(page(1), true)
+		c.put(page(2), false)
+
+		ps := c.dirtyPages()
+		assert.Len(ps, 2)
+		assert.Equal(pageNo(1), ps[0].pageNo)
+		assert.Equal(pageNo(3), ps[1].pageNo)
+
+		// they're dirty until they're written.
+		assert.Len(c.dirtyPages(), 2)
+		_, ok := c.get(1)
+		assert.True(ok)
+
+		c.clean()
+		assert.Empty(c.dirtyPages())
+		_, ok = c.get(1)
+		assert.False(ok)
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c := newCache(2)
+		c.put(page(1), true)
+		c.put(page(2), false)
+
+		c.discard()
+		_, ok := c.get(1)
+		assert.False(ok)
+		_, ok = c.get(2)
+		assert.True(ok)
+		assert.Empty(c.dirtyPages())
+	})
+}
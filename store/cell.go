@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 
 	"github.com/ugorji/go/codec"
 
@@ -13,35 +14,56 @@ import (
 )
 
 type cell struct {
-	size int
+	size   int
+	packed bool      // whether the cell takes only the bytes of its header and payload instead of size.
+	keys   keyFormat // how the key is written.
+	prefix []byte    // prefix of the encoded key stored in the page instead of the cell.
 
 	overflow pageNo // Points to the overflow page if it's not large enough. otherwise zero-value.
 	Payload
+
+	raw    []byte // encoded Payload. it lacks the part in overflow pages until it's inflated.
+	length uint32 // length of the whole encoded Payload.
+	key    []byte // encoded Key. it's computed on demand unless it's read from the file.
 }
 
 const cellHeaderSize = 4 + 4 // overflow + Payload Size
 
+var errNoOverflow = errors.New("payload too large without overflow page")
+
 func (c *cell) ReadFrom(r io.Reader) (int64, error) {
 	if err := binary.Read(r, binary.BigEndian, &c.overflow); err != nil {
 		return 0, errors.Wrap(err, "failed to read cell overflow")
 	}
 
-	var size uint32
-	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+	if err := binary.Read(r, binary.BigEndian, &c.length); err != nil {
 		return 0, errors.Wrap(err, "failed to read key size")
 	}
 
-	b := bytes.NewBuffer(make([]byte, 0, size))
-	if _, err := io.CopyN(b, r, int64(size)); err != nil {
-		return 0, err
+	size := c.length
+	if n := uint32(c.size - cellHeaderSize); size > n {
+		size = n
 	}
 
-	d := codec.NewDecoder(b, &handle)
-	if err := d.Decode(&c.Payload); err != nil {
+	c.raw = make([]byte, size)
+	if _, err := io.ReadFull(r, c.raw); err != nil {
 		return 0, err
 	}
 
-	// TODO: overflow
+	if !c.inflated() && c.overflow == 0 {
+		return 0, errNoOverflow
+	}
+
+	if c.inflated() {
+		if err := c.decode(); err != nil {
+			return 0, err
+		}
+	}
+
+	if c.packed {
+		return int64(cellHeaderSize + size), nil
+	}
+
 	if _, err := io.CopyN(ioutil.Discard, r, int64(c.size)-int64(cellHeaderSize)-int64(size)); err != nil {
 		return 0, err
 	}
@@ -49,28 +71,201 @@ func (c *cell) ReadFrom(r io.Reader) (int64, error) {
 	return int64(c.size), nil
 }
 
+// decodeFrom reads the cell from the beginning of b like ReadFrom. the payload is copied out of b.
+func (c *cell) decodeFrom(b []byte) (int, error) {
+	if len(b) < cellHeaderSize {
+		return 0, errors.Wrap(io.ErrUnexpectedEOF, "failed to read cell header")
+	}
+	c.overflow = pageNo(binary.BigEndian.Uint32(b))
+	c.length = binary.BigEndian.Uint32(b[4:])
+
+	size := int(c.length)
+	if n := c.size - cellHeaderSize; size > n {
+		size = n
+	}
+	if len(b) < cellHeaderSize+size {
+		return 0, errors.Wrap(io.ErrUnexpectedEOF, "failed to read payload")
+	}
+	c.raw = make([]byte, size)
+	copy(c.raw, b[cellHeaderSize:])
+
+	if !c.inflated() && c.overflow == 0 {
+		return 0, errNoOverflow
+	}
+
+	if c.inflated() {
+		if err := c.decode(); err != nil {
+			return 0, err
+		}
+	}
+
+	if c.packed {
+		return cellHeaderSize + size, nil
+	}
+	if len(b) < c.size {
+		return 0, errors.Wrap(io.ErrUnexpectedEOF, "failed to read cell")
+	}
+	return c.size, nil
+}
+
 func (c *cell) WriteTo(w io.Writer) (int64, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, c.size))
 	if err := binary.Write(buf, binary.BigEndian, c.overflow); err != nil {
 		return 0, err
 	}
 
-	b := bytes.NewBuffer(nil)
-	e := codec.NewEncoder(b, &handle)
-	if err := e.Encode(&c.Payload); err != nil {
+	b, err := c.encode(c.keys, c.prefix)
+	if err != nil {
 		return 0, err
 	}
-	if err := binary.Write(buf, binary.BigEndian, uint32(len(b.Bytes()))); err != nil {
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(b))); err != nil {
 		return 0, err
 	}
-	if _, err := b.WriteTo(buf); err != nil {
+	if n := c.size - cellHeaderSize; len(b) > n {
+		if c.overflow == 0 {
+			return 0, errNoOverflow
+		}
+		b = b[:n]
+	}
+	if _, err := buf.Write(b); err != nil {
 		return 0, err
 	}
 
+	if c.packed {
+		n, err := w.Write(buf.Bytes())
+		return int64(n), err
+	}
+
 	n, err := w.Write(buf.Bytes()[:c.size])
 	return int64(n), err
 }
 
+// inflated reports whether the cell has the whole encoded Payload.
+func (c *cell) inflated() bool {
+	return len(c.raw) == int(c.length)
+}
+
+// encode encodes the payload. the key is encoded in the format f without prefix.
+func (c *cell) encode(f keyFormat, prefix []byte) ([]byte, error) {
+	p := c.Payload
+	if f != cborKeys {
+		k, err := c.sortKey()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(k, prefix) || len(prefix) > 0 && len(k) == len(prefix) {
+			return nil, errors.Errorf("key %#v doesn't extend prefix %x", c.Key, prefix)
+		}
+		p.Key, p.EncodedKey = nil, k[len(prefix):]
+	}
+	var b bytes.Buffer
+	e := codec.NewEncoder(&b, &handle)
+	if err := e.Encode(&p); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (c *cell) decode() error {
+	d := codec.NewDecoderBytes(c.raw, &handle)
+	if err := d.Decode(&c.Payload); err != nil {
+		return err
+	}
+	c.key = nil
+	if c.EncodedKey != nil {
+		k := append(append([]byte{}, c.prefix...), c.EncodedKey...)
+		key, err := decodeKey(k)
+		if err != nil {
+			return err
+		}
+		c.Key, c.key, c.EncodedKey = key, k, nil
+	}
+	return nil
+}
+
+// sortKey returns the encoded key which is ordered byte-wise.
+func (c *cell) sortKey() ([]byte, error) {
+	if c.key == nil {
+		k, err := encodeKey(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.key = k
+	}
+	return c.key, nil
+}
+
+// encodedLength returns the length of the payload encoded in the format f without prefix. once the cell is encoded, it's
+// computed from the encoded length and the lengths of the key without the prefixes instead of encoding it again.
+func (c *cell) encodedLength(f keyFormat, prefix []byte) (int, error) {
+	if c.raw == nil {
+		raw, err := c.encode(f, prefix)
+		if err != nil {
+			return 0, err
+		}
+		return len(raw), nil
+	}
+	if f == cborKeys {
+		return int(c.length), nil
+	}
+	k, err := c.sortKey()
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.HasPrefix(k, prefix) || len(prefix) > 0 && len(k) == len(prefix) {
+		return 0, errors.Errorf("key %#v doesn't extend prefix %x", c.Key, prefix)
+	}
+	m, n := len(k)-len(c.prefix), len(k)-len(prefix)
+	if m == 0 || n == 0 {
+		// an empty key is omitted from the payload.
+		raw, err := c.encode(f, prefix)
+		if err != nil {
+			return 0, err
+		}
+		return len(raw), nil
+	}
+	return int(c.length) - bytesLength(m) + bytesLength(n), nil
+}
+
+// bytesLength returns the length of a byte string of n bytes in CBOR.
+func bytesLength(n int) int {
+	switch {
+	case n < 24:
+		return 1 + n
+	case n <= math.MaxUint8:
+		return 2 + n
+	case n <= math.MaxUint16:
+		return 3 + n
+	case n <= math.MaxUint32:
+		return 5 + n
+	default:
+		return 9 + n
+	}
+}
+
+// changed drops the encoded payload which doesn't match the payload anymore so that it's encoded again.
+func (c *cell) changed() {
+	c.raw, c.length = nil, 0
+}
+
+// setKey replaces the key with the one of o.
+func (c *cell) setKey(o *cell) {
+	c.Key, c.key = o.Key, o.key
+	c.changed()
+}
+
+// setValue replaces the value with v.
+func (c *cell) setValue(v values) {
+	c.Value = v
+	c.changed()
+}
+
+// setRight replaces the right pointer with r.
+func (c *cell) setRight(r pageNo) {
+	c.Right = r
+	c.changed()
+}
+
 func (c cell) GoString() string {
 	if c.Value == nil {
 		return fmt.Sprintf("%#v->%d", c.Key, c.Right)
@@ -84,4 +279,6 @@ type Payload struct {
 	Key     values `codec:"1,omitempty"`
 	Value   values `codec:"2,omitempty"`
 	Right   pageNo `codec:"3,omitempty"`
+
+	EncodedKey []byte `codec:"4,omitempty"` // Key in files of ordered keys. it's only set while encoding.
 }
@@ -2,6 +2,7 @@ package store
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -174,4 +175,98 @@ func TestCell_WriteTo(t *testing.T) {
 			0x00, 0x00, 0x00, 0x00,
 		}, w.Bytes())
 	})
+
+	t.Run("too large", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c := cell{size: 32}
+		c.Key = values{1}
+		c.Value = values{"a long value which doesn't fit in a cell"}
+
+		var w bytes.Buffer
+		_, err := c.WriteTo(&w)
+		assert.Equal(errNoOverflow, err)
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c := cell{size: 16}
+		c.overflow = 2
+		c.Right = 1
+		c.Key = values{1, 2}
+		c.Value = values{3, 4}
+
+		var w bytes.Buffer
+		n, err := c.WriteTo(&w)
+		assert.NoError(err)
+		assert.Equal(int64(16), n)
+
+		assert.Equal([]byte{
+			0x00, 0x00, 0x00, 0x02, // overflow: 2
+			0x00, 0x00, 0x00, 0x0b, // payload size: 11
+			0xa3, 0x01, 0x82, 0x01, // payload: {1:[1, 2], 2:[3, 4], 3:1} (first 8 bytes)
+			0x02, 0x02, 0x82, 0x03,
+		}, w.Bytes())
+
+		var d cell
+		d.size = 16
+		_, err = d.ReadFrom(bytes.NewReader(w.Bytes()))
+		assert.NoError(err)
+		assert.False(d.inflated())
+		assert.Equal(pageNo(2), d.overflow)
+		assert.Equal(uint32(11), d.length)
+		assert.Len(d.raw, 8)
+	})
+}
+
+func TestCell_EncodedLength(t *testing.T) {
+	t.Run("prefix", func(t *testing.T) {
+		assert := assert.New(t)
+
+		for _, s := range []string{"a", strings.Repeat("a", 30), strings.Repeat("a", 300)} {
+			c := cell{Payload: Payload{Key: values{"k", s}, Value: values{s}}}
+			k, err := c.sortKey()
+			assert.NoError(err)
+
+			// the key without the prefixes crosses the lengths where the header of a byte string grows.
+			prefixes := [][]byte{nil}
+			for _, n := range []int{1, 23, 24, 25, 255, 256, 257} {
+				if n < len(k) {
+					prefixes = append(prefixes, k[:len(k)-n])
+				}
+			}
+			for _, f := range []keyFormat{orderedKeys, compressedKeys} {
+				for _, p := range prefixes {
+					c.prefix = p
+					raw, err := c.encode(f, p)
+					assert.NoError(err)
+					c.raw, c.length = raw, uint32(len(raw))
+					for _, q := range prefixes {
+						raw, err := c.encode(f, q)
+						assert.NoError(err)
+						n, err := c.encodedLength(f, q)
+						assert.NoError(err)
+						assert.Equal(len(raw), n)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		assert := assert.New(t)
+
+		c := cell{Payload: Payload{Key: values{1}, Value: values{"foo"}}}
+		raw, err := c.encode(cborKeys, nil)
+		assert.NoError(err)
+		c.raw, c.length = raw, uint32(len(raw))
+
+		c.setValue(values{"foobar"})
+		raw, err = c.encode(cborKeys, nil)
+		assert.NoError(err)
+		n, err := c.encodedLength(cborKeys, nil)
+		assert.NoError(err)
+		assert.Equal(len(raw), n)
+	})
 }
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"fmt"
+	"io"
+)
+
+// Report is the result of Check.
+type Report struct {
+	Pages    int   // number of pages in the file including the header
+	Trees    []int // roots of the checked trees. the first one is the catalog.
+	Problems []Problem
+}
+
+// OK tells if no problems are found.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Problem is an inconsistency found in a page.
+type Problem struct {
+	PageNo  int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("page %d: %s", p.PageNo, p.Message)
+}
+
+// Check validates the file. it walks the catalog, the tree at the header root whose values begin with the roots of
+// other trees, and every tree registered in it. then it walks the free list and reports the pages which are reachable
+// from none of them.
+func (b *BTree) Check() (*Report, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	c := checker{
+		btree:  b,
+		report: &Report{Pages: int(b.pages)},
+		seen:   map[pageNo]bool{},
+	}
+
+	if b.RootPageNo != 0 {
+		c.catalog(b.RootPageNo)
+	}
+	c.freeList()
+
+	for i := pageNo(1); i < b.pages; i++ {
+		if !c.seen[i] {
+			c.problem(i, "orphaned page")
+		}
+	}
+
+	return c.report, nil
+}
+
+type checker struct {
+	btree  *BTree
+	report *Report
+	seen   map[pageNo]bool
+}
+
+func (c *checker) problem(n pageNo, format string, args ...interface{}) {
+	c.report.Problems = append(c.report.Problems, Problem{
+		PageNo:  int(n),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// visit marks the page as reachable and gets it. it returns nil if the page is already visited or unreadable.
+func (c *checker) visit(n pageNo, from pageNo) *Page {
+	if n == 0 || n >= c.btree.pages {
+		c.problem(from, "page number out of range: %d", n)
+		return nil
+	}
+	if c.seen[n] {
+		c.problem(n, "page referenced more than once")
+		return nil
+	}
+	c.seen[n] = true
+	p, err := c.btree.get(n)
+	if err != nil {
+		c.problem(n, "%v", err)
+		return nil
+	}
+	return p
+}
+
+func (c *checker) catalog(root pageNo) {
+	if !c.tree(root) {
+		return
+	}
+	iter, err := c.btree.first(int(root))
+	if err != nil {
+		c.problem(root, "%v", err)
+		return
+	}
+	for {
+		if err := iter.next(); err != nil {
+			if err != io.EOF {
+				c.problem(iter.page.pageNo, "%v", err)
+			}
+			return
+		}
+		var r pageNo
+		if len(iter.Value) > 0 {
+			switch v := iter.Value[0].(type) {
+			case uint64:
+				r = pageNo(v)
+			case int64:
+				r = pageNo(v)
+			case int:
+				r = pageNo(v)
+			}
+		}
+		if r == 0 {
+			c.problem(iter.page.pageNo, "catalog entry %#v has no root", values(iter.Key))
+			continue
+		}
+		if r >= c.btree.pages {
+			c.problem(iter.page.pageNo, "catalog entry %#v points at page out of range: %d", values(iter.Key), r)
+			continue
+		}
+		c.tree(r)
+	}
+}
+
+// tree checks the tree rooted at root and tells if all of its pages are readable.
+func (c *checker) tree(root pageNo) bool {
+	c.report.Trees = append(c.report.Trees, int(root))
+
+	w := walk{depth: -1}
+	if !c.page(&w, root, 0, nil, nil, 0) {
+		return false
+	}
+
+	for i, l := range w.leaves {
+		var prev, next pageNo
+		if i > 0 {
+			prev = w.leaves[i-1].pageNo
+		}
+		if i < len(w.leaves)-1 {
+			next = w.leaves[i+1].pageNo
+		}
+		if l.prev != prev {
+			c.problem(l.pageNo, "prev is %d, expected %d", l.prev, prev)
+		}
+		if l.next != next {
+			c.problem(l.pageNo, "next is %d, expected %d", l.next, next)
+		}
+	}
+	return true
+}
+
+// walk is the state of a walk through a tree.
+type walk struct {
+	depth  int     // depth of the leaves found first
+	leaves []*Page // leaves in key order
+}
+
+// page checks the page n and its descendants and tells if all of them are readable.
+// every key in them must be in the range [lo, hi) where nil means unbounded.
+func (c *checker) page(w *walk, n, from pageNo, lo, hi values, depth int) bool {
+	p := c.visit(n, from)
+	if p == nil {
+		return false
+	}
+
+	ok := true
+	for i := range p.cells {
+		k := values(p.cells[i].Key)
+		if i > 0 {
+			if d, ok := c.compare(n, values(p.cells[i-1].Key), k); ok && d >= 0 {
+				c.problem(n, "key %#v is not greater than the previous key %#v", k, values(p.cells[i-1].Key))
+			}
+		}
+		if lo != nil {
+			if d, ok := c.compare(n, k, lo); ok && d < 0 {
+				c.problem(n, "key %#v is less than the separator %#v", k, lo)
+			}
+		}
+		if hi != nil {
+			if d, ok := c.compare(n, k, hi); ok && d >= 0 {
+				c.problem(n, "key %#v is not less than the separator %#v", k, hi)
+			}
+		}
+		if !c.overflow(n, p.cells[i].overflow) {
+			ok = false
+		}
+	}
+
+	switch p.pageType {
+	case leaf:
+		if w.depth < 0 {
+			w.depth = depth
+		}
+		if depth != w.depth {
+			c.problem(n, "leaf at depth %d, expected %d", depth, w.depth)
+		}
+		w.leaves = append(w.leaves, p)
+	case branch:
+		if len(p.cells) == 0 {
+			c.problem(n, "empty branch")
+		}
+		for i := -1; i < len(p.cells); i++ {
+			l, h := lo, hi
+			if i >= 0 {
+				l = p.cells[i].Key
+			}
+			if i < len(p.cells)-1 {
+				h = p.cells[i+1].Key
+			}
+			if !c.page(w, p.childAt(i), n, l, h, depth+1) {
+				ok = false
+			}
+		}
+	default:
+		c.problem(n, "invalid page type: %s", p.pageType)
+		ok = false
+	}
+	return ok
+}
+
+// compare compares the keys in the page n and tells if they're comparable. if not, it reports them as a problem.
+func (c *checker) compare(n pageNo, k, o values) (int, bool) {
+	d, err := k.compare(o)
+	if err != nil {
+		c.problem(n, "%v", err)
+		return 0, false
+	}
+	return d, true
+}
+
+// overflow checks the chain of overflow pages beginning with o.
+func (c *checker) overflow(from, o pageNo) bool {
+	for o != 0 {
+		p := c.visit(o, from)
+		if p == nil {
+			return false
+		}
+		if p.pageType != overflow {
+			c.problem(o, "invalid page type: %s", p.pageType)
+			return false
+		}
+		from, o = o, p.next
+	}
+	return true
+}
+
+func (c *checker) freeList() {
+	var n uint32
+	for f, from := c.btree.FreePageNo, pageNo(0); f != 0; n++ {
+		p := c.visit(f, from)
+		if p == nil {
+			return
+		}
+		if p.pageType != free {
+			c.problem(f, "invalid page type: %s", p.pageType)
+			return
+		}
+		from, f = f, p.next
+	}
+	if n != c.btree.FreePageCount {
+		c.problem(0, "free page count is %d, expected %d", c.btree.FreePageCount, n)
+	}
+}
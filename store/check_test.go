@@ -0,0 +1,159 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBTree_Check(t *testing.T) {
+	// setup creates a catalog with a table of 100 rows.
+	setup := func(t *testing.T) (*BTree, int, func()) {
+		assert := assert.New(t)
+
+		dir, err := ioutil.TempDir("", "test")
+		assert.NoError(err)
+
+		b, err := Create(filepath.Join(dir, "test.db"), PageSize(256), CellSize(32))
+		assert.NoError(err)
+
+		c, err := b.CreateRoot()
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(c))
+
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for i := 0; i < 100; i++ {
+			r, err = b.Insert(r, values{i}, values{fmt.Sprintf("%d", i)})
+			assert.NoError(err)
+		}
+		for i := 0; i < 100; i += 2 {
+			r, err = b.Delete(r, values{i})
+			assert.NoError(err)
+		}
+		c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(c))
+
+		return b, r, func() {
+			assert.NoError(b.Close())
+			assert.NoError(os.RemoveAll(dir))
+		}
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, r, teardown := setup(t)
+		defer teardown()
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.True(report.OK(), "%v", report.Problems)
+		assert.Equal([]int{b.Root(), r}, report.Trees)
+		assert.Equal(int(b.pages), report.Pages)
+	})
+
+	t.Run("broken chain", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, r, teardown := setup(t)
+		defer teardown()
+
+		iter, err := b.First(r)
+		assert.NoError(err)
+		p := iter.page
+		next := p.next
+		p.next = 0
+		assert.NoError(b.update(p))
+		assert.NoError(b.commit())
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Equal([]Problem{
+			{PageNo: int(p.pageNo), Message: fmt.Sprintf("next is 0, expected %d", next)},
+		}, report.Problems)
+	})
+
+	t.Run("unordered keys", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, r, teardown := setup(t)
+		defer teardown()
+
+		iter, err := b.First(r)
+		assert.NoError(err)
+		p := iter.page
+		for len(p.cells) < 2 {
+			p, err = b.get(p.next)
+			assert.NoError(err)
+		}
+		p.cells[0], p.cells[1] = p.cells[1], p.cells[0]
+		assert.NoError(b.update(p))
+		assert.NoError(b.commit())
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Equal([]Problem{
+			{PageNo: int(p.pageNo), Message: fmt.Sprintf("key %#v is not greater than the previous key %#v", values(p.cells[1].Key), values(p.cells[0].Key))},
+		}, report.Problems)
+	})
+
+	t.Run("orphaned page", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, _, teardown := setup(t)
+		defer teardown()
+
+		o, err := b.CreateRoot()
+		assert.NoError(err)
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Equal([]Problem{
+			{PageNo: o, Message: "orphaned page"},
+		}, report.Problems)
+	})
+
+	t.Run("invalid root", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, _, teardown := setup(t)
+		defer teardown()
+
+		c, err := b.Insert(b.Root(), values{"index", "bar"}, values{1000, "create index bar"})
+		assert.NoError(err)
+		assert.NoError(b.UpdateRoot(c))
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Equal([]Problem{
+			{PageNo: c, Message: `catalog entry ["index", "bar"] points at page out of range: 1000`},
+		}, report.Problems)
+	})
+
+	t.Run("free list", func(t *testing.T) {
+		assert := assert.New(t)
+
+		b, _, teardown := setup(t)
+		defer teardown()
+
+		o, err := b.CreateRoot()
+		assert.NoError(err)
+		assert.NoError(b.Drop(o))
+
+		assert.NotZero(b.FreePageCount)
+		b.FreePageCount++
+		assert.NoError(b.commit())
+
+		report, err := b.Check()
+		assert.NoError(err)
+		assert.Equal([]Problem{
+			{PageNo: 0, Message: fmt.Sprintf("free page count is %d, expected %d", b.FreePageCount, b.FreePageCount-1)},
+		}, report.Problems)
+	})
+}
@@ -1,17 +1,41 @@
 package store
 
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrStaleIterator is returned when the iterator was created before Vacuum renumbered the pages.
+var ErrStaleIterator = xerrors.New("stale iterator")
+
+// Iterator walks the cells in the leaves. Next and Prev return io.EOF at the ends of the tree or the range.
 type Iterator struct {
 	*cell
 
-	btree *BTree
-	page  *Page
-	index int
+	btree      *BTree
+	generation int // generation of btree when the iterator is created
+	page       *Page
+	index      int
+	lower      Bound
+	upper      Bound
 }
 
 func (i *Iterator) Next() error {
-	if i.index == len(i.page.cells)-1 {
+	i.btree.mu.RLock()
+	defer i.btree.mu.RUnlock()
+	return i.next()
+}
+
+func (i *Iterator) next() error {
+	if i.generation != i.btree.generation {
+		return ErrStaleIterator
+	}
+	if i.index >= len(i.page.cells)-1 {
 		if i.page.next == 0 {
-			return ErrNotFound
+			i.index = len(i.page.cells)
+			return io.EOF
 		}
 		p, err := i.btree.get(i.page.next)
 		if err != nil {
@@ -22,6 +46,105 @@ func (i *Iterator) Next() error {
 	} else {
 		i.index++
 	}
-	i.cell = &i.page.cells[i.index]
+	k, err := i.page.cells[i.index].sortKey()
+	if err != nil {
+		return err
+	}
+	if !i.upper.upperOf(k) {
+		return io.EOF
+	}
+	i.setCell()
 	return nil
 }
+
+// Prev moves the iterator to the previous cell following the prev links of the leaves.
+func (i *Iterator) Prev() error {
+	i.btree.mu.RLock()
+	defer i.btree.mu.RUnlock()
+	return i.prev()
+}
+
+func (i *Iterator) prev() error {
+	if i.generation != i.btree.generation {
+		return ErrStaleIterator
+	}
+	if i.index <= 0 {
+		if i.page.prev == 0 {
+			i.index = -1
+			return io.EOF
+		}
+		p, err := i.btree.get(i.page.prev)
+		if err != nil {
+			return err
+		}
+		i.page = p
+		i.index = len(p.cells) - 1
+	} else {
+		i.index--
+	}
+	k, err := i.page.cells[i.index].sortKey()
+	if err != nil {
+		return err
+	}
+	if !i.lower.lowerOf(k) {
+		return io.EOF
+	}
+	i.setCell()
+	return nil
+}
+
+// setCell copies the current cell so that modifying Key or Value of the iterator doesn't affect the cached page.
+func (i *Iterator) setCell() {
+	c := i.page.cells[i.index]
+	c.Key, c.Value = c.Key.clone(), c.Value.clone()
+	i.cell = &c
+}
+
+// Bound is an end of a range. a nil Key means unbounded.
+// a Key shorter than the keys in the tree is compared with their prefixes.
+type Bound struct {
+	Key       []interface{}
+	Exclusive bool
+
+	key []byte // encoded Key
+}
+
+func (b *Bound) encode() error {
+	k, err := encodeKey(b.Key)
+	if err != nil {
+		return err
+	}
+	b.key = k
+	return nil
+}
+
+// lowerOf tells if the encoded key k is within the range bounded below by b.
+func (b Bound) lowerOf(k []byte) bool {
+	if b.Key == nil {
+		return true
+	}
+	if b.Exclusive {
+		return b.compare(k) < 0
+	}
+	return b.compare(k) <= 0
+}
+
+// upperOf tells if the encoded key k is within the range bounded above by b.
+func (b Bound) upperOf(k []byte) bool {
+	if b.Key == nil {
+		return true
+	}
+	if b.Exclusive {
+		return b.compare(k) > 0
+	}
+	return b.compare(k) >= 0
+}
+
+// compare compares b with the prefix of k. since every value in encoded keys is self-delimiting, the prefix of the
+// same length as b is ordered as the prefix of the same number of values.
+func (b Bound) compare(k []byte) int {
+	if len(k) > len(b.key) {
+		k = k[:len(b.key)]
+	}
+	return bytes.Compare(b.key, k)
+}
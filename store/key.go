@@ -0,0 +1,199 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// keyFormat is how keys are stored in cells.
+type keyFormat uint32
+
+const (
+	cborKeys       keyFormat = iota // keys are encoded in CBOR along with values.
+	orderedKeys                     // keys are encoded so that they're ordered byte-wise.
+	compressedKeys                  // ordered keys whose prefix shared in a slotted page is stored once in the page.
+)
+
+// tags of the values in encoded keys. values of different types are ordered by these tags:
+// NULL < integers < floats < strings < bytes < booleans < times.
+// new tags are appended so that the existing files keep their order.
+const (
+	tagNull     byte = 0x01
+	tagNegative byte = 0x02 // negative integer
+	tagInteger  byte = 0x03 // non-negative integer
+	tagFloat    byte = 0x04
+	tagString   byte = 0x05
+	tagBytes    byte = 0x06
+	tagBool     byte = 0x07
+	tagTime     byte = 0x08
+)
+
+// encodeKey encodes the key so that bytes.Compare of encoded keys agrees with the order of the keys.
+// every value is self-delimiting so a key is also ordered after its prefixes.
+func encodeKey(key values) ([]byte, error) {
+	var b []byte
+	for _, v := range key {
+		switch v := v.(type) {
+		case nil:
+			b = append(b, tagNull)
+		case int:
+			b = appendInteger(b, int64(v))
+		case int64:
+			b = appendInteger(b, v)
+		case uint64:
+			b = append(b, tagInteger)
+			b = appendUint64(b, v)
+		case float64:
+			u := math.Float64bits(v)
+			if u&(1<<63) != 0 {
+				u = ^u
+			} else {
+				u |= 1 << 63
+			}
+			b = append(b, tagFloat)
+			b = appendUint64(b, u)
+		case string:
+			b = append(b, tagString)
+			b = appendEscaped(b, []byte(v))
+		case []byte:
+			b = append(b, tagBytes)
+			b = appendEscaped(b, v)
+		case bool:
+			b = append(b, tagBool)
+			if v {
+				b = append(b, 0x01)
+			} else {
+				b = append(b, 0x00)
+			}
+		case time.Time:
+			// seconds since the epoch with the sign bit flipped followed by nanoseconds so that no time collides.
+			b = append(b, tagTime)
+			b = appendUint64(b, uint64(v.Unix())^1<<63)
+			b = append(b, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(b[len(b)-4:], uint32(v.Nanosecond()))
+		default:
+			return nil, &ErrNotComparable{Value: v}
+		}
+	}
+	return b, nil
+}
+
+func appendInteger(b []byte, v int64) []byte {
+	if v < 0 {
+		// two's complement keeps the order among negative integers.
+		return appendUint64(append(b, tagNegative), uint64(v))
+	}
+	return appendUint64(append(b, tagInteger), uint64(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendEscaped appends the bytes replacing 0x00 with 0x00 0xff and terminates them with 0x00 0x01.
+func appendEscaped(b, v []byte) []byte {
+	for _, c := range v {
+		b = append(b, c)
+		if c == 0x00 {
+			b = append(b, 0xff)
+		}
+	}
+	return append(b, 0x00, 0x01)
+}
+
+var errInvalidKey = xerrors.New("invalid key")
+
+// decodeKey decodes the key encoded by encodeKey. integers are decoded into int64 if negative or uint64 otherwise as
+// they're decoded from CBOR. times are decoded in UTC.
+func decodeKey(b []byte) (values, error) {
+	var key values
+	for len(b) > 0 {
+		tag := b[0]
+		b = b[1:]
+		switch tag {
+		case tagNull:
+			key = append(key, nil)
+		case tagNegative, tagInteger, tagFloat:
+			if len(b) < 8 {
+				return nil, errInvalidKey
+			}
+			u := binary.BigEndian.Uint64(b)
+			b = b[8:]
+			switch tag {
+			case tagNegative:
+				key = append(key, int64(u))
+			case tagInteger:
+				key = append(key, u)
+			case tagFloat:
+				if u&(1<<63) != 0 {
+					u &^= 1 << 63
+				} else {
+					u = ^u
+				}
+				key = append(key, math.Float64frombits(u))
+			}
+		case tagString, tagBytes:
+			v, n, err := unescape(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[n:]
+			if tag == tagString {
+				key = append(key, string(v))
+			} else {
+				key = append(key, v)
+			}
+		case tagBool:
+			if len(b) < 1 || b[0] > 0x01 {
+				return nil, errInvalidKey
+			}
+			key = append(key, b[0] == 0x01)
+			b = b[1:]
+		case tagTime:
+			if len(b) < 12 {
+				return nil, errInvalidKey
+			}
+			sec := int64(binary.BigEndian.Uint64(b) ^ 1<<63)
+			nsec := binary.BigEndian.Uint32(b[8:])
+			if nsec >= 1e9 {
+				return nil, errInvalidKey
+			}
+			key = append(key, time.Unix(sec, int64(nsec)).UTC())
+			b = b[12:]
+		default:
+			return nil, errInvalidKey
+		}
+	}
+	return key, nil
+}
+
+// unescape returns the bytes escaped by appendEscaped and the number of bytes it read.
+func unescape(b []byte) ([]byte, int, error) {
+	var v []byte
+	for i := 0; ; {
+		j := bytes.IndexByte(b[i:], 0x00)
+		if j < 0 || i+j+1 >= len(b) {
+			return nil, 0, errInvalidKey
+		}
+		v = append(v, b[i:i+j]...)
+		i += j
+		switch b[i+1] {
+		case 0xff:
+			v = append(v, 0x00)
+			i += 2
+		case 0x01:
+			if v == nil {
+				v = []byte{}
+			}
+			return v, i + 2, nil
+		default:
+			return nil, 0, errInvalidKey
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeKey(t *testing.T) {
+	t.Run("order", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// in ascending order.
+		keys := []values{
+			{nil},
+			{int64(math.MinInt64)},
+			{-256},
+			{-1},
+			{0},
+			{1},
+			{256},
+			{uint64(math.MaxUint64)},
+			{math.Inf(-1)},
+			{-1.5},
+			{-0.5},
+			{0.0},
+			{0.5},
+			{1.5},
+			{math.Inf(1)},
+			{""},
+			{"", ""},
+			{"\x00"},
+			{"a"},
+			{"a", 1},
+			{"a\x00"},
+			{"a\x00b"},
+			{"a\x01"},
+			{"ab"},
+			{"b"},
+			{[]byte{}},
+			{[]byte{0x00}},
+			{[]byte{0x00, 0xff}},
+			{[]byte{0x01}},
+			{false},
+			{true},
+			{time.Time{}},
+			{time.Unix(-1, 999999999)},
+			{time.Unix(0, 0)},
+			{time.Unix(0, 1)},
+			{time.Unix(0, 1000)},
+			{time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)},
+			{time.Date(2020, 1, 2, 3, 4, 5, 6001, time.UTC)},
+		}
+		var prev []byte
+		for i, k := range keys {
+			b, err := encodeKey(k)
+			assert.NoError(err)
+			if i > 0 {
+				assert.Equal(-1, bytes.Compare(prev, b), "%#v should be less than %#v", keys[i-1], k)
+			}
+			prev = b
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		assert := assert.New(t)
+
+		at := time.Date(1960, 1, 2, 3, 4, 5, 6789, time.FixedZone("JST", 9*60*60))
+		k := values{nil, -1, 1, 1.5, "a\x00b", []byte{0x00, 0xff}, "", true, false, at, time.Time{}}
+		b, err := encodeKey(k)
+		assert.NoError(err)
+		v, err := decodeKey(b)
+		assert.NoError(err)
+		assert.Equal(values{nil, int64(-1), uint64(1), 1.5, "a\x00b", []byte{0x00, 0xff}, "", true, false, time.Date(1960, 1, 1, 18, 4, 5, 6789, time.UTC), time.Time{}}, v)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := decodeKey([]byte{tagInteger, 0x00})
+		assert.Equal(errInvalidKey, err)
+
+		_, err = decodeKey([]byte{tagString, 'a', 0x00})
+		assert.Equal(errInvalidKey, err)
+
+		_, err = decodeKey([]byte{tagBool, 0x02})
+		assert.Equal(errInvalidKey, err)
+
+		_, err = decodeKey([]byte{tagTime, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x3b, 0x9a, 0xca, 0x00})
+		assert.Equal(errInvalidKey, err)
+
+		_, err = decodeKey([]byte{0xff})
+		assert.Equal(errInvalidKey, err)
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package store
+
+import (
+	"os"
+	"time"
+)
+
+// lockFile does nothing since advisory locks are only supported on Unix-like platforms.
+func lockFile(f *os.File, exclusive bool, timeout time.Duration) error {
+	return nil
+}
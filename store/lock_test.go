@@ -0,0 +1,121 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
+)
+
+func TestLockFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(256), CellSize(32))
+	assert.NoError(err)
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err = b.Insert(r, values{1}, values{"foo"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(r))
+
+	// flock locks conflict between open files even in the same process.
+	_, err = Open(name)
+	assert.True(xerrors.Is(err, ErrLocked))
+
+	// the file is left as it is.
+	_, err = Create(name)
+	assert.True(xerrors.Is(err, ErrLocked))
+
+	start := time.Now()
+	_, err = Open(name, LockTimeout(50*time.Millisecond))
+	assert.True(xerrors.Is(err, ErrLocked))
+	assert.True(time.Since(start) >= 50*time.Millisecond)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(b.Close())
+	}()
+	o, err := Open(name, LockTimeout(10*time.Second))
+	assert.NoError(err)
+	v, err := o.Search(o.Root(), values{1})
+	assert.NoError(err)
+	assert.Equal([]interface{}{"foo"}, v)
+
+	// a reader conflicts with the writer.
+	_, err = Open(name, ReadOnly(true))
+	assert.True(xerrors.Is(err, ErrLocked))
+	assert.NoError(o.Close())
+
+	// readers share the file but the writer waits for them.
+	r1, err := Open(name, ReadOnly(true))
+	assert.NoError(err)
+	r2, err := Open(name, ReadOnly(true))
+	assert.NoError(err)
+	_, err = Open(name)
+	assert.True(xerrors.Is(err, ErrLocked))
+	assert.NoError(r1.Close())
+	assert.NoError(r2.Close())
+	o, err = Open(name)
+	assert.NoError(err)
+	defer func() { assert.NoError(o.Close()) }()
+}
+
+func TestLockFile_Vacuum(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(256), CellSize(32))
+	assert.NoError(err)
+	c, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(c))
+
+	// the waiter waits for the lock of the file which Vacuum replaces.
+	var closed int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		o, err := Open(name, LockTimeout(10*time.Second))
+		if !assert.NoError(err) {
+			return
+		}
+		defer func() { assert.NoError(o.Close()) }()
+		assert.Equal(int32(1), atomic.LoadInt32(&closed))
+		v, err := o.Search(r, values{1})
+		assert.NoError(err)
+		assert.Equal([]interface{}{"foo"}, v)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	assert.NoError(b.Vacuum())
+	time.Sleep(50 * time.Millisecond)
+	vs, err := b.Search(b.Root(), values{"table", "foo"})
+	assert.NoError(err)
+	r = int(vs[0].(uint64))
+	_, err = b.Insert(r, values{1}, values{"foo"})
+	assert.NoError(err)
+	atomic.StoreInt32(&closed, 1)
+	assert.NoError(b.Close())
+	<-done
+}
@@ -0,0 +1,38 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package store
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockInterval is how often a lock held by another process is tried again.
+const lockInterval = 10 * time.Millisecond
+
+// lockFile takes an advisory lock of the file. the lock is exclusive for writers and shared for readers. if another
+// process holds a conflicting lock, it retries until the timeout and then returns ErrLocked. the lock is released when
+// the file is closed.
+func lockFile(f *os.File, exclusive bool, timeout time.Duration) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		switch {
+		case err == nil:
+			return nil
+		case err == syscall.EINTR:
+			continue
+		case err != syscall.EWOULDBLOCK:
+			return err
+		case !time.Now().Before(deadline):
+			return ErrLocked
+		}
+		time.Sleep(lockInterval)
+	}
+}
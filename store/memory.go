@@ -0,0 +1,58 @@
+package store
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// Memory is a storage in memory which grows as it's written. the zero value is an empty storage.
+type Memory struct {
+	mu  sync.RWMutex
+	buf []byte
+}
+
+func (m *Memory) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset: %d", off)
+	}
+	if off >= int64(len(m.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *Memory) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if off < 0 {
+		return 0, xerrors.Errorf("negative offset: %d", off)
+	}
+	if end := int(off) + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+// replace replaces the contents with the ones of o.
+func (m *Memory) replace(o *Memory) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buf = append(m.buf[:0], o.buf...)
+}
+
+// Size returns the number of bytes written so far including the gaps.
+func (m *Memory) Size() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.buf)), nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	var m Memory
+	buf := make([]byte, 4)
+	n, err := m.ReadAt(buf, 0)
+	assert.Equal(io.EOF, err)
+	assert.Equal(0, n)
+
+	n, err = m.WriteAt([]byte{1, 2}, 2)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	size, err := m.Size()
+	assert.NoError(err)
+	assert.Equal(int64(4), size)
+
+	n, err = m.ReadAt(buf, 0)
+	assert.NoError(err)
+	assert.Equal(4, n)
+	assert.Equal([]byte{0, 0, 1, 2}, buf)
+
+	n, err = m.WriteAt([]byte{3, 4, 5}, 3)
+	assert.NoError(err)
+	assert.Equal(3, n)
+	size, err = m.Size()
+	assert.NoError(err)
+	assert.Equal(int64(6), size)
+
+	n, err = m.ReadAt(buf, 3)
+	assert.Equal(io.EOF, err)
+	assert.Equal(3, n)
+	assert.Equal([]byte{3, 4, 5}, buf[:n])
+}
+
+func TestCreateStorage(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &Memory{}
+	b, err := CreateStorage(m, PageSize(256), CellSize(32))
+	assert.NoError(err)
+
+	c, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	for i := 0; i < 1000; i++ {
+		r, err = b.Insert(r, values{i}, values{"foo"})
+		assert.NoError(err)
+	}
+	for i := 0; i < 1000; i += 2 {
+		r, err = b.Delete(r, values{i})
+		assert.NoError(err)
+	}
+	c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(c))
+	assert.NoError(b.Close())
+	size, err := m.Size()
+	assert.NoError(err)
+	assert.Equal(int64(b.pages)*256, size)
+
+	b, err = OpenStorage(m)
+	assert.NoError(err)
+	assert.Equal(uint32(256), b.PageSize)
+
+	count := func() int {
+		vs, err := b.Search(b.Root(), values{"table", "foo"})
+		assert.NoError(err)
+		iter, err := b.First(int(vs[0].(uint64)))
+		assert.NoError(err)
+		var n int
+		for iter.Next() == nil {
+			n++
+		}
+		return n
+	}
+	assert.Equal(500, count())
+
+	pages := b.pages
+	assert.NoError(b.Vacuum())
+	assert.True(b.pages < pages, "%d >= %d", b.pages, pages)
+	assert.Equal(500, count())
+	report, err := b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+
+	// the memory has the compacted image and the writes after it.
+	vs, err := b.Search(b.Root(), values{"table", "foo"})
+	assert.NoError(err)
+	r, err = b.Insert(int(vs[0].(uint64)), values{0}, values{"foo"})
+	assert.NoError(err)
+	_, err = b.Update(b.Root(), values{"table", "foo"}, values{r, vs[1]})
+	assert.NoError(err)
+	assert.NoError(b.Close())
+	size, err = m.Size()
+	assert.NoError(err)
+	assert.Equal(int64(b.pages)*256, size)
+
+	b, err = OpenStorage(m)
+	assert.NoError(err)
+	assert.Equal(501, count())
+	assert.NoError(b.Close())
+
+	_, err = OpenStorage(&Memory{})
+	assert.Error(err)
+}
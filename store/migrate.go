@@ -0,0 +1,139 @@
+package store
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// Migrate copies the file src into a new file dst which stores keys in the current format.
+// it copies the catalog, the tree at the header root, and every tree registered in it with their roots renumbered.
+// each tree is copied in a transaction of its own so that the changes kept in memory don't grow with the file.
+func Migrate(src, dst string) (err error) {
+	s, err := Open(src, ReadOnly(true))
+	if err != nil {
+		return xerrors.Errorf("failed to open source: %w", err)
+	}
+	defer func() {
+		if e := s.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	d, err := Create(dst, PageSize(s.PageSize), CellSize(s.CellSize), pageFormat(s.Format))
+	if err != nil {
+		return xerrors.Errorf("failed to create destination: %w", err)
+	}
+	defer func() {
+		if e := d.Close(); err == nil {
+			err = e
+		}
+	}()
+
+	if s.RootPageNo == 0 {
+		return nil
+	}
+
+	entries, err := migrateTrees(s, d)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	c, err := migrateCatalog(entries, tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.UpdateRoot(c); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateTrees copies the trees registered in the catalog of s into d and returns the catalog entries with the new
+// roots.
+func migrateTrees(s, d *BTree) (pairList, error) {
+	iter, err := s.First(s.Root())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get first: %w", err)
+	}
+	var entries pairList
+	for {
+		if err := iter.Next(); err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, xerrors.Errorf("failed to iterate catalog: %w", err)
+		}
+		if len(iter.Value) == 0 {
+			return nil, xerrors.Errorf("catalog entry %#v has no root", values(iter.Key))
+		}
+		o, ok := iter.Value[0].(uint64)
+		if !ok {
+			return nil, xerrors.Errorf("catalog entry %#v has no root", values(iter.Key))
+		}
+		r, err := migrateTree(s, d, int(o))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to migrate %#v: %w", values(iter.Key), err)
+		}
+		v := append([]interface{}{uint64(r)}, iter.Value[1:]...)
+		entries = append(entries, [2][]interface{}{iter.Key, v})
+	}
+}
+
+// migrateCatalog inserts the catalog entries into a new catalog and returns its root.
+func migrateCatalog(entries pairList, d *Tx) (int, error) {
+	c, err := d.CreateRoot()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to create catalog: %w", err)
+	}
+	for _, e := range entries {
+		c, err = d.Insert(c, e[0], e[1])
+		if err != nil {
+			return 0, xerrors.Errorf("failed to insert catalog entry: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// migrateTree copies the tree of s at root into d in a transaction and returns the new root.
+func migrateTree(s, d *BTree, root int) (_ int, err error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	r, err := tx.CreateRoot()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to create root: %w", err)
+	}
+	iter, err := s.First(root)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to get first: %w", err)
+	}
+	for {
+		if err := iter.Next(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, xerrors.Errorf("failed to iterate: %w", err)
+		}
+		r, err = tx.Insert(r, iter.Key, iter.Value)
+		if err != nil {
+			return 0, xerrors.Errorf("failed to insert: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, xerrors.Errorf("failed to commit: %w", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	src := filepath.Join(dir, "src.db")
+	b, err := Create(src, PageSize(256), CellSize(48), keyEncoding(cborKeys))
+	assert.NoError(err)
+
+	c, err := b.CreateRoot()
+	assert.NoError(err)
+	for _, name := range []string{"foo", "bar"} {
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for i := 0; i < 100; i++ {
+			r, err = b.Insert(r, values{i, name}, values{fmt.Sprint(i)})
+			assert.NoError(err)
+		}
+		c, err = b.Insert(c, values{"table", name}, values{r, "create table " + name})
+		assert.NoError(err)
+	}
+	assert.NoError(b.UpdateRoot(c))
+	assert.NoError(b.Close())
+
+	// files of CBOR keys are still readable.
+	b, err = Open(src)
+	assert.NoError(err)
+	assert.Equal(cborKeys, b.Keys)
+	report, err := b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+	assert.NoError(b.Close())
+
+	dst := filepath.Join(dir, "dst.db")
+	assert.NoError(Migrate(src, dst))
+
+	b, err = Open(dst)
+	assert.NoError(err)
+	defer func() { assert.NoError(b.Close()) }()
+	assert.Equal(compressedKeys, b.Keys)
+	assert.Equal(uint32(256), b.PageSize)
+	assert.Equal(uint32(48), b.CellSize)
+
+	report, err = b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+	assert.Len(report.Trees, 3)
+
+	for _, name := range []string{"foo", "bar"} {
+		v, err := b.Search(b.Root(), values{"table", name})
+		assert.NoError(err)
+		assert.Len(v, 2)
+		assert.Equal("create table "+name, v[1])
+
+		iter, err := b.First(int(v[0].(uint64)))
+		assert.NoError(err)
+		for i := 0; i < 100; i++ {
+			assert.NoError(iter.Next())
+			assert.Equal(values{uint64(i), name}, iter.Key)
+			assert.Equal(values{fmt.Sprint(i)}, iter.Value)
+		}
+		assert.Equal(io.EOF, iter.Next())
+	}
+}
+
+func TestMigrate_Baseline(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	// testdata/baseline.db is written by the first version which had neither page checksums nor ordered keys.
+	// it has a table foo of keys {0, "foo"} to {19, "foo"}.
+	bs, err := ioutil.ReadFile(filepath.Join("testdata", "baseline.db"))
+	assert.NoError(err)
+	src := filepath.Join(dir, "src.db")
+	assert.NoError(ioutil.WriteFile(src, bs, 0600))
+
+	b, err := Open(src)
+	assert.NoError(err)
+	assert.Equal(uint32(0), b.Checksums)
+	assert.Equal(fixedCells, b.Format)
+	assert.Equal(cborKeys, b.Keys)
+	report, err := b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+	assert.NoError(b.Close())
+
+	// the source is only read so that the other readers can keep it open.
+	r, err := Open(src, ReadOnly(true))
+	assert.NoError(err)
+	dst := filepath.Join(dir, "dst.db")
+	assert.NoError(Migrate(src, dst))
+	assert.NoError(r.Close())
+	after, err := ioutil.ReadFile(src)
+	assert.NoError(err)
+	assert.Equal(bs, after)
+
+	b, err = Open(dst)
+	assert.NoError(err)
+	defer func() { assert.NoError(b.Close()) }()
+	assert.Equal(uint32(1), b.Checksums)
+	assert.Equal(compressedKeys, b.Keys)
+
+	v, err := b.Search(b.Root(), values{"table", "foo"})
+	assert.NoError(err)
+	assert.Len(v, 2)
+	assert.Equal("create table foo", v[1])
+
+	iter, err := b.First(int(v[0].(uint64)))
+	assert.NoError(err)
+	for i := 0; i < 20; i++ {
+		assert.NoError(iter.Next())
+		assert.Equal(values{uint64(i), "foo"}, iter.Key)
+		assert.Equal(values{fmt.Sprint(i)}, iter.Value)
+	}
+	assert.Equal(io.EOF, iter.Next())
+}
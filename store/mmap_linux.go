@@ -0,0 +1,112 @@
+//go:build linux
+// +build linux
+
+package store
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// mappedFile serves reads from a shared memory mapping of the file while writes go to the file as usual. the mapping
+// sees the writes since both share the page cache. it's remapped when a read goes beyond it after the file grows.
+type mappedFile struct {
+	*os.File
+	mu   sync.RWMutex
+	data []byte
+}
+
+func mapFile(f *fileStorage) (Storage, error) {
+	m := mappedFile{File: f.File}
+	if err := m.remap(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// remap maps the whole file again.
+func (m *mappedFile) remap() error {
+	if err := m.unmap(); err != nil {
+		return err
+	}
+	size, err := m.Size()
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	data, err := syscall.Mmap(int(m.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return xerrors.Errorf("failed to map: %w", err)
+	}
+	m.data = data
+	return nil
+}
+
+func (m *mappedFile) unmap() error {
+	if m.data == nil {
+		return nil
+	}
+	if err := syscall.Munmap(m.data); err != nil {
+		return xerrors.Errorf("failed to unmap: %w", err)
+	}
+	m.data = nil
+	return nil
+}
+
+func (m *mappedFile) view(off int64, n int, f func(buf []byte) error) error {
+	end := off + int64(n)
+	m.mu.RLock()
+	if end <= int64(len(m.data)) {
+		defer m.mu.RUnlock()
+		return f(m.data[off:end])
+	}
+	m.mu.RUnlock()
+
+	m.mu.Lock()
+	if end > int64(len(m.data)) {
+		if err := m.remap(); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	m.mu.Unlock()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if end > int64(len(m.data)) {
+		return xerrors.Errorf("failed to read page: %w", io.ErrUnexpectedEOF)
+	}
+	return f(m.data[off:end])
+}
+
+func (m *mappedFile) Size() (int64, error) {
+	return fileSize(m.File)
+}
+
+func (m *mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	err := m.view(off, len(p), func(buf []byte) error {
+		n = copy(p, buf)
+		return nil
+	})
+	if err != nil {
+		// fall back to the file for a read beyond the end.
+		return m.File.ReadAt(p, off)
+	}
+	return n, nil
+}
+
+func (m *mappedFile) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.unmap(); err != nil {
+		return err
+	}
+	return m.File.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package store
+
+// mapFile returns the file as it is since memory mapping is only supported on Linux.
+func mapFile(f *fileStorage) (Storage, error) {
+	return f, nil
+}
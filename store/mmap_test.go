@@ -0,0 +1,74 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryMap(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(256), CellSize(32), MemoryMap(true), CacheSize(0))
+	assert.NoError(err)
+	if runtime.GOOS == "linux" {
+		assert.Implements((*viewer)(nil), b.file)
+	}
+
+	// the file grows while it's mapped.
+	c, err := b.CreateRoot()
+	assert.NoError(err)
+	r, err := b.CreateRoot()
+	assert.NoError(err)
+	for i := 0; i < 1000; i++ {
+		r, err = b.Insert(r, values{i}, values{"foo"})
+		assert.NoError(err)
+	}
+	c, err = b.Insert(c, values{"table", "foo"}, values{r, "create table foo"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(c))
+
+	// pages updated in place are read as written.
+	for i := 0; i < 1000; i += 2 {
+		r, err = b.Update(r, values{i}, values{"bar"})
+		assert.NoError(err)
+	}
+	for i := 0; i < 1000; i++ {
+		v, err := b.Search(r, values{i})
+		assert.NoError(err)
+		if i%2 == 0 {
+			assert.Equal([]interface{}{"bar"}, v)
+		} else {
+			assert.Equal([]interface{}{"foo"}, v)
+		}
+	}
+	report, err := b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+
+	assert.NoError(b.Vacuum())
+	if runtime.GOOS == "linux" {
+		assert.Implements((*viewer)(nil), b.file)
+	}
+	report, err = b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+	assert.NoError(b.Close())
+
+	b, err = Open(name, MemoryMap(true))
+	assert.NoError(err)
+	defer func() { assert.NoError(b.Close()) }()
+	report, err = b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+	assert.Equal(1, len(report.Trees)-1)
+}
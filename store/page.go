@@ -5,8 +5,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -38,9 +36,19 @@ func (t pageType) String() string {
 	}
 }
 
+// format is the layout of cells in pages.
+type format uint32
+
+const (
+	fixedCells   format = iota // every cell takes cellSize bytes.
+	slottedCells               // an array of offsets points to cells packed at the end of the page.
+)
+
 type Page struct {
 	size     int
-	cellSize int
+	cellSize int // size of a fixed cell or the maximum size of a slotted cell
+	format   format
+	keys     keyFormat
 
 	pageNo   pageNo
 	pageType pageType
@@ -48,60 +56,136 @@ type Page struct {
 	prev     pageNo
 	left     pageNo // leftmost pointer in branch page
 	cells    []cell
+	data     []byte // fragment of a payload in overflow page
 }
 
 const pageHeaderSize = 1 + 3 + 4 + 4 + 4
 
+// slotSize is the size of an offset to a cell in a slotted page.
+const slotSize = 2
+
+// prefixHeaderSize is the size of the length of the prefix of keys in a compressed page.
+const prefixHeaderSize = 2
+
 func NewPage(size, cellSize int) *Page {
-	return &Page{
+	return newPage(size, cellSize, fixedCells)
+}
+
+func newPage(size, cellSize int, f format) *Page {
+	p := Page{
 		size:     size,
 		cellSize: cellSize,
-		cells:    make([]cell, 0, (size-pageHeaderSize)/cellSize),
+		format:   f,
 	}
+	if f == fixedCells {
+		p.cells = make([]cell, 0, p.capacity())
+	}
+	return &p
 }
 
-func (p *Page) ReadFrom(r io.Reader) (int64, error) {
-	buf := bytes.NewBuffer(make([]byte, 0, p.size))
+// capacity returns the number of fixed cells in the page.
+func (p *Page) capacity() int {
+	return (p.size - pageHeaderSize) / p.cellSize
+}
 
-	n, err := io.CopyN(buf, r, int64(p.size))
+func (p *Page) clone() *Page {
+	q := *p
+	q.cells = make([]cell, len(p.cells), cap(p.cells))
+	copy(q.cells, p.cells)
+	return &q
+}
+
+func (p *Page) ReadFrom(r io.Reader) (int64, error) {
+	b := make([]byte, p.size)
+	n, err := io.ReadFull(r, b)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to read page")
 	}
-
-	if err := binary.Read(buf, binary.BigEndian, &p.pageType); err != nil {
-		return 0, errors.Wrap(err, "failed to read page type")
-	}
-
-	if _, err := io.CopyN(ioutil.Discard, buf, 1); err != nil {
-		return 0, errors.Wrap(err, "failed to skip 1 byte")
+	if err := p.decode(b); err != nil {
+		return 0, err
 	}
+	return int64(n), nil
+}
 
-	var size uint16
-	if err := binary.Read(buf, binary.BigEndian, &size); err != nil {
-		return 0, errors.Wrap(err, "failed to read size")
+// decode decodes the page from b of the page size. the cells copy their payloads out of b so that b can be reused or
+// unmapped afterwards.
+func (p *Page) decode(b []byte) error {
+	if len(b) < p.size {
+		return errors.Wrap(io.ErrUnexpectedEOF, "failed to read page")
 	}
-
-	if err := binary.Read(buf, binary.BigEndian, &p.next); err != nil {
-		return 0, errors.Wrap(err, "failed to read next page no")
+	b = b[:p.size]
+
+	p.pageType = pageType(b[0])
+	// b[1] is the checksum.
+	size := int(binary.BigEndian.Uint16(b[2:]))
+	p.next = pageNo(binary.BigEndian.Uint32(b[4:]))
+	p.prev = pageNo(binary.BigEndian.Uint32(b[8:]))
+	p.left = pageNo(binary.BigEndian.Uint32(b[12:]))
+
+	if p.pageType == overflow {
+		if size > p.size-pageHeaderSize {
+			return errors.Errorf("invalid size of data: %d", size)
+		}
+		p.data = make([]byte, size)
+		copy(p.data, b[pageHeaderSize:])
+		return nil
 	}
 
-	if err := binary.Read(buf, binary.BigEndian, &p.prev); err != nil {
-		return 0, errors.Wrap(err, "failed to read prev page no")
+	if p.format == slottedCells {
+		return p.decodeSlots(b, size)
 	}
 
-	if err := binary.Read(buf, binary.BigEndian, &p.left); err != nil {
-		return 0, errors.Wrap(err, "failed to read left page no")
+	if size > p.capacity() {
+		return errors.Errorf("invalid number of cells: %d", size)
 	}
-
 	p.cells = p.cells[:size]
 	for i := range p.cells {
 		p.cells[i].size = p.cellSize
-		if _, err := p.cells[i].ReadFrom(buf); err != nil {
-			return 0, errors.Wrapf(err, "failed to read cell: %d", i)
+		o := pageHeaderSize + i*p.cellSize
+		if _, err := p.cells[i].decodeFrom(b[o:]); err != nil {
+			return errors.Wrapf(err, "failed to read cell: %d", i)
 		}
 	}
+	return nil
+}
 
-	return int64(n), nil
+// decodeSlots decodes the offsets following the page header and the cells they point to.
+// a compressed page has the prefix of keys and its length between them.
+func (p *Page) decodeSlots(b []byte, n int) error {
+	end := pageHeaderSize
+	var prefix []byte
+	if p.compressed() {
+		if end+prefixHeaderSize > len(b) {
+			return errors.New("failed to read prefix length")
+		}
+		l := int(binary.BigEndian.Uint16(b[end:]))
+		end += prefixHeaderSize
+		if end+l > len(b) {
+			return errors.New("failed to read prefix")
+		}
+		prefix = make([]byte, l)
+		copy(prefix, b[end:])
+		end += l
+	}
+	slots := end
+	end += slotSize * n
+	if end > len(b) {
+		return errors.New("failed to read offsets")
+	}
+	p.cells = make([]cell, n)
+	for i := range p.cells {
+		o := int(binary.BigEndian.Uint16(b[slots+slotSize*i:]))
+		if o < end || o >= len(b) {
+			return errors.Errorf("invalid offset of cell %d: %d", i, o)
+		}
+		p.cells[i].size = p.cellSize
+		p.cells[i].packed = true
+		p.cells[i].prefix = prefix
+		if _, err := p.cells[i].decodeFrom(b[o:]); err != nil {
+			return errors.Wrapf(err, "failed to read cell: %d", i)
+		}
+	}
+	return nil
 }
 
 func (p *Page) WriteTo(w io.Writer) (int64, error) {
@@ -111,11 +195,15 @@ func (p *Page) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 
-	if _, err := buf.Write(make([]byte, 1)); err != nil {
+	if _, err := buf.Write(make([]byte, 1)); err != nil { // checksum
 		return 0, err
 	}
 
-	if err := binary.Write(buf, binary.BigEndian, uint16(len(p.cells))); err != nil {
+	size := len(p.cells)
+	if p.pageType == overflow {
+		size = len(p.data)
+	}
+	if err := binary.Write(buf, binary.BigEndian, uint16(size)); err != nil {
 		return 0, err
 	}
 
@@ -131,108 +219,364 @@ func (p *Page) WriteTo(w io.Writer) (int64, error) {
 		return 0, err
 	}
 
+	if _, err := buf.Write(p.data); err != nil {
+		return 0, err
+	}
+
+	var prefix []byte
+	if p.compressed() {
+		prefix = p.keyPrefix(p.cells)
+		if err := binary.Write(buf, binary.BigEndian, uint16(len(prefix))); err != nil {
+			return 0, err
+		}
+		if _, err := buf.Write(prefix); err != nil {
+			return 0, err
+		}
+	}
+
+	var packed []byte // cells packed at the end of a slotted page
 	for _, c := range p.cells {
 		c.size = p.cellSize
+		c.keys = p.keys
+		c.prefix = prefix
+		if p.format == slottedCells {
+			c.packed = true
+			var cb bytes.Buffer
+			if _, err := c.WriteTo(&cb); err != nil {
+				return 0, err
+			}
+			packed = append(cb.Bytes(), packed...)
+			if err := binary.Write(buf, binary.BigEndian, uint16(p.size-len(packed))); err != nil {
+				return 0, err
+			}
+			continue
+		}
 		if _, err := c.WriteTo(buf); err != nil {
 			return 0, err
 		}
 	}
+	if buf.Len()+len(packed) > p.size {
+		return 0, errPageOverflow
+	}
 
-	n, err := w.Write(buf.Bytes()[:p.size])
+	b := buf.Bytes()[:p.size]
+	copy(b[p.size-len(packed):], packed)
+	b[1] = pageChecksum(b)
+	n, err := w.Write(b)
 	return int64(n), err
 }
 
+// pageChecksum folds CRC-32 of the page into a byte. the checksum byte in the page header is regarded as zero.
+func pageChecksum(b []byte) uint8 {
+	s := checksum(b[:1], []byte{0}, b[2:])
+	return uint8(s ^ s>>8 ^ s>>16 ^ s>>24)
+}
+
+// ErrCorruptPage is returned when the page doesn't match its checksum.
+type ErrCorruptPage struct {
+	PageNo int
+}
+
+func (e *ErrCorruptPage) Error() string {
+	return fmt.Sprintf("corrupt page: %d", e.PageNo)
+}
+
 var ErrDuplicateKey = errors.New("duplicate key")
 
+var errPageOverflow = errors.New("cells don't fit in page")
+
 func (p *Page) Insert(c *cell) error {
-	i := sort.Search(len(p.cells), func(i int) bool {
-		return p.cells[i].Key.compare(c.Key) >= 0
-	})
-	if len(p.cells) > 0 && i < len(p.cells) && p.cells[i].Key.compare(c.Key) == 0 {
+	k, err := c.sortKey()
+	if err != nil {
+		return err
+	}
+	i, ok, err := p.find(k)
+	if err != nil {
+		return err
+	}
+	if ok {
 		return ErrDuplicateKey
 	}
-	p.cells = p.cells[:len(p.cells)+1]
+	p.cells = append(p.cells, cell{})
 	copy(p.cells[i+1:], p.cells[i:])
 	p.cells[i] = *c
 	return nil
 }
 
-func (p *Page) willOverflow() bool {
-	return len(p.cells)+1 > cap(p.cells)
+// compressed tells if the page stores the prefix shared by the encoded keys of its cells only once.
+func (p *Page) compressed() bool {
+	return p.format == slottedCells && p.keys == compressedKeys && p.pageType != overflow
+}
+
+// footprint returns the number of bytes the cell takes in the page whose keys share prefix.
+func (p *Page) footprint(c *cell, prefix []byte) int {
+	if p.format == fixedCells {
+		return p.cellSize
+	}
+	n, err := c.encodedLength(p.keys, prefix)
+	if err != nil {
+		return slotSize + p.cellSize
+	}
+	if m := p.cellSize - cellHeaderSize; n > m {
+		n = m
+	}
+	return slotSize + cellHeaderSize + n
 }
 
-func (p *Page) Contains(key values) bool {
-	if len(p.cells) == 0 {
+// keyPrefix returns the prefix of the encoded keys shared by the cells if storing it once makes the page smaller.
+// every key keeps at least a byte of its own.
+func (p *Page) keyPrefix(cells []cell) []byte {
+	if !p.compressed() || len(cells) == 0 {
+		return nil
+	}
+	var prefix []byte
+	for i := range cells {
+		k, err := cells[i].sortKey()
+		if err != nil || len(k) == 0 {
+			return nil
+		}
+		if i == 0 {
+			prefix = k[:len(k)-1]
+			continue
+		}
+		var n int
+		for n < len(prefix) && n < len(k)-1 && prefix[n] == k[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	if len(prefix) == 0 || p.sizeOf(cells, prefix) >= p.sizeOf(cells, nil) {
+		return nil
+	}
+	return prefix
+}
+
+// sizeOf returns the number of bytes the page takes with the cells whose keys share prefix.
+func (p *Page) sizeOf(cells []cell, prefix []byte) int {
+	n := pageHeaderSize
+	if p.compressed() {
+		n += prefixHeaderSize + len(prefix)
+	}
+	for i := range cells {
+		n += p.footprint(&cells[i], prefix)
+	}
+	return n
+}
+
+// usedBy returns the number of bytes the page takes with the cells.
+func (p *Page) usedBy(cells []cell) int {
+	return p.sizeOf(cells, p.keyPrefix(cells))
+}
+
+// used returns the number of bytes the page header and the cells take.
+func (p *Page) used() int {
+	return p.usedBy(p.cells)
+}
+
+func (p *Page) willOverflow(c *cell) bool {
+	if p.format == fixedCells {
+		return len(p.cells)+1 > p.capacity()
+	}
+	cells := make([]cell, len(p.cells), len(p.cells)+1)
+	copy(cells, p.cells)
+	return p.usedBy(append(cells, *c)) > p.size
+}
+
+func (p *Page) underflow() bool {
+	if p.format == fixedCells {
+		return len(p.cells) < p.capacity()/2
+	}
+	return 2*(p.used()-pageHeaderSize) < p.size-pageHeaderSize
+}
+
+// canLend reports whether p doesn't underflow without the cell at i. a page never lends its last cell.
+func (p *Page) canLend(i int) bool {
+	if len(p.cells) < 2 {
 		return false
 	}
+	if p.format == fixedCells {
+		return len(p.cells) > p.capacity()/2
+	}
+	cells := make([]cell, 0, len(p.cells)-1)
+	cells = append(cells, p.cells[:i]...)
+	cells = append(cells, p.cells[i+1:]...)
+	return 2*(p.usedBy(cells)-pageHeaderSize) >= p.size-pageHeaderSize
+}
+
+// canMerge reports whether the cells of o (and the separator sep from the parent if branch) fit in p.
+func (p *Page) canMerge(o *Page, sep *cell) bool {
+	if p.format == fixedCells {
+		n := len(p.cells) + len(o.cells)
+		if p.pageType == branch {
+			n++
+		}
+		return n <= p.capacity()
+	}
+	cells := make([]cell, 0, len(p.cells)+len(o.cells)+1)
+	cells = append(cells, p.cells...)
+	if p.pageType == branch {
+		cells = append(cells, *sep)
+	}
+	cells = append(cells, o.cells...)
+	return p.usedBy(cells) <= p.size
+}
 
-	i := sort.Search(len(p.cells), func(i int) bool {
-		return key.compare(p.cells[i].Key) >= 0
-	})
+// canReplace reports whether the cell at i can be replaced with c without overflowing the page.
+func (p *Page) canReplace(i int, c *cell) bool {
+	if p.format == fixedCells {
+		return true
+	}
+	cells := make([]cell, len(p.cells))
+	copy(cells, p.cells)
+	cells[i] = *c
+	return p.usedBy(cells) <= p.size
+}
 
-	return i < len(p.cells) && key.compare(p.cells[0].Key) == 0
+// middle returns the index to split the cells into halves. fixed cells are split by count and slotted cells by bytes.
+func (p *Page) middle(cells []cell) int {
+	if p.format == fixedCells {
+		return len(cells) / 2
+	}
+	var total int
+	for i := range cells {
+		total += p.footprint(&cells[i], nil)
+	}
+	var n, m int
+	for m = 0; m < len(cells)-1; m++ {
+		if 2*n >= total {
+			break
+		}
+		n += p.footprint(&cells[m], nil)
+	}
+	if m < 1 {
+		m = 1
+	}
+	return m
+}
+
+func (p *Page) Contains(key values) (bool, error) {
+	k, err := encodeKey(key)
+	if err != nil {
+		return false, err
+	}
+	_, ok, err := p.find(k)
+	return ok, err
 }
 
 func (p *Page) Delete(key values) error {
-	i := sort.Search(len(p.cells), func(i int) bool {
-		return key.compare(p.cells[i].Key) >= 0
-	})
-	if len(p.cells) == 0 || i >= len(p.cells) || p.cells[i].Key.compare(key) != 0 {
+	k, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	i, ok, err := p.find(k)
+	if err != nil {
+		return err
+	}
+	if !ok {
 		return ErrNotFound
 	}
 	p.cells = p.cells[:i+copy(p.cells[i:], p.cells[i+1:])]
 	return nil
 }
 
+// find returns the index of the cell with the encoded key k and whether it exists.
+func (p *Page) find(k []byte) (int, bool, error) {
+	i, err := p.search(func(l []byte) bool {
+		return bytes.Compare(l, k) >= 0
+	})
+	if err != nil || i == len(p.cells) {
+		return i, false, err
+	}
+	l, err := p.cells[i].sortKey()
+	return i, bytes.Equal(l, k), err
+}
+
+// search returns the index of the first cell whose encoded key satisfies f.
+func (p *Page) search(f func(k []byte) bool) (int, error) {
+	return search(len(p.cells), func(i int) (bool, error) {
+		k, err := p.cells[i].sortKey()
+		if err != nil {
+			return false, err
+		}
+		return f(k), nil
+	})
+}
+
 func (p *Page) InsertSplit(c *cell) (*Page, error) {
+	return p.insertSplit(c, false)
+}
+
+// insertSplit inserts c and splits the cells into p and a new right page. if appending is set and c goes after the
+// last cell, p is left as full as it is and c goes to the right page alone so that increasing keys fill pages up.
+func (p *Page) insertSplit(c *cell, appending bool) (*Page, error) {
 	cells := make([]cell, len(p.cells)+1)
-	i := sort.Search(len(p.cells), func(i int) bool {
-		return c.Key.compare(p.cells[i].Key) <= 0
-	})
-	if i < len(p.cells) && c.Key.compare(p.cells[i].Key) == 0 {
+	k, err := c.sortKey()
+	if err != nil {
+		return nil, err
+	}
+	i, ok, err := p.find(k)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
 		return nil, ErrDuplicateKey
 	}
 	copy(cells[:i], p.cells[:i])
 	cells[i] = *c
 	copy(cells[i+1:], p.cells[i:])
 
-	m := len(cells) / 2
+	m := p.middle(cells)
+	if appending && i == len(p.cells) {
+		m = i
+	}
 
-	p.cells = p.cells[:m]
-	copy(p.cells, cells[:m])
+	p.cells = append(p.cells[:0], cells[:m]...)
 
-	r := NewPage(p.size, p.cellSize)
+	r := newPage(p.size, p.cellSize, p.format)
+	r.keys = p.keys
 	r.pageType = p.pageType
-	r.cells = r.cells[:m]
-	copy(r.cells, cells[m:])
+	r.cells = append(r.cells, cells[m:]...)
 
 	return r, nil
 }
 
-func (p *Page) InsertSplitMiddle(c *cell) (*Page, values, error) {
+func (p *Page) InsertSplitMiddle(c *cell) (*Page, *cell, error) {
+	return p.insertSplitMiddle(c, false)
+}
+
+// insertSplitMiddle inserts c and splits the cells into p, the cell in the middle and a new right page. if appending
+// is set and c goes after the last cell, the last cell of p goes up and c goes to the right page alone.
+func (p *Page) insertSplitMiddle(c *cell, appending bool) (*Page, *cell, error) {
 	cells := make([]cell, len(p.cells)+1)
-	i := sort.Search(len(p.cells), func(i int) bool {
-		return c.Key.compare(p.cells[i].Key) <= 0
-	})
-	if i < len(p.cells) && c.Key.compare(p.cells[i].Key) == 0 {
+	k, err := c.sortKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	i, ok, err := p.find(k)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
 		return nil, nil, ErrDuplicateKey
 	}
 	copy(cells[:i], p.cells[:i])
 	cells[i] = *c
 	copy(cells[i+1:], p.cells[i:])
 
-	m := len(cells) / 2
+	m := p.middle(cells)
+	if m > len(cells)-2 || appending && i == len(p.cells) {
+		m = len(cells) - 2 // leave a cell for the right.
+	}
 
-	p.cells = p.cells[:m]
-	copy(p.cells, cells[:m])
+	p.cells = append(p.cells[:0], cells[:m]...)
 
-	r := NewPage(p.size, p.cellSize)
+	r := newPage(p.size, p.cellSize, p.format)
+	r.keys = p.keys
 	r.pageType = p.pageType
 	r.left = cells[m].Right
-	r.cells = r.cells[:m-1]
-	copy(r.cells, cells[m+1:])
+	r.cells = append(r.cells, cells[m+1:]...)
 
-	return r, cells[m].Key, nil
+	return r, &cells[m], nil
 }
 
 func (p *Page) GoString() string {
@@ -247,11 +591,24 @@ func (p *Page) GoString() string {
 	}
 }
 
-func (p *Page) child(key values) pageNo {
-	i := sort.Search(len(p.cells), func(i int) bool {
-		return key.compare(p.cells[i].Key) < 0
+func (p *Page) child(k []byte) (pageNo, error) {
+	i, err := p.childIndex(k)
+	if err != nil {
+		return 0, err
+	}
+	return p.childAt(i), nil
+}
+
+// childIndex returns the index of the cell pointing to the child which may contain the encoded key k or -1 for the
+// leftmost child.
+func (p *Page) childIndex(k []byte) (int, error) {
+	i, err := p.search(func(l []byte) bool {
+		return bytes.Compare(k, l) < 0
 	})
-	i -= 1
+	return i - 1, err
+}
+
+func (p *Page) childAt(i int) pageNo {
 	if i < 0 {
 		return p.left
 	}
@@ -201,7 +201,7 @@ func TestPage_WriteTo(t *testing.T) {
 		assert.Equal(int64(32), n)
 
 		assert.Equal([]byte{
-			0x01, 0x00, 0x00, 0x00, // page type: branch, page cell count: 0
+			0x01, 0x6f, 0x00, 0x00, // page type: branch, checksum: 0x6f, page cell count: 0
 			0x00, 0x00, 0x00, 0x00, // page next: 0
 			0x00, 0x00, 0x00, 0x00, // page prev: 0
 			0x00, 0x00, 0x00, 0x00, // page left: 0
@@ -228,7 +228,7 @@ func TestPage_WriteTo(t *testing.T) {
 		assert.Equal(int64(32), n)
 
 		assert.Equal([]byte{
-			0x01, 0x00, 0x00, 0x01, // page type: branch, page cell count: 1
+			0x01, 0x41, 0x00, 0x01, // page type: branch, checksum: 0x41, page cell count: 1
 			0x00, 0x00, 0x00, 0x00, // page next: 0
 			0x00, 0x00, 0x00, 0x00, // page prev: 0
 			0x00, 0x00, 0x00, 0x00, // page left: 0
@@ -241,6 +241,182 @@ func TestPage_WriteTo(t *testing.T) {
 	})
 }
 
+func TestPage_Overflow(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewPage(32, 16)
+	p.pageType = overflow
+	p.next = 3
+	p.data = []byte{0x01, 0x02, 0x03}
+
+	var w bytes.Buffer
+	n, err := p.WriteTo(&w)
+	assert.NoError(err)
+	assert.Equal(int64(32), n)
+
+	assert.Equal([]byte{
+		0x03, 0x36, 0x00, 0x03, // page type: overflow, checksum: 0x36, data size: 3
+		0x00, 0x00, 0x00, 0x03, // page next: 3
+		0x00, 0x00, 0x00, 0x00, // page prev: 0
+		0x00, 0x00, 0x00, 0x00, // page left: 0
+
+		0x01, 0x02, 0x03, 0x00, // data
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}, w.Bytes())
+
+	q := NewPage(32, 16)
+	n, err = q.ReadFrom(&w)
+	assert.NoError(err)
+	assert.Equal(int64(32), n)
+	assert.Equal(overflow, q.pageType)
+	assert.Equal(pageNo(3), q.next)
+	assert.Equal([]byte{0x01, 0x02, 0x03}, q.data)
+}
+
+func TestPage_Slotted(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newPage(64, 24, slottedCells)
+	p.pageType = leaf
+	p.cells = append(p.cells,
+		cell{Payload: Payload{Key: values{1}}},
+		cell{Payload: Payload{Key: values{2}, Value: values{"a"}}},
+	)
+
+	var w bytes.Buffer
+	n, err := p.WriteTo(&w)
+	assert.NoError(err)
+	assert.Equal(int64(64), n)
+
+	assert.Equal([]byte{
+		0x02, 0x2b, 0x00, 0x02, // page type: leaf, checksum: 0x2b, page cell count: 2
+		0x00, 0x00, 0x00, 0x00, // page next: 0
+		0x00, 0x00, 0x00, 0x00, // page prev: 0
+		0x00, 0x00, 0x00, 0x00, // page left: 0
+
+		0x00, 0x34, 0x00, 0x24, // cell offsets: 52, 36
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // cell overflow: 0
+		0x00, 0x00, 0x00, 0x08, // cell payload size: 8
+		0xa2, 0x01, 0x81, 0x02, // cell payload: {1:[2],2:["a"]}
+
+		0x02, 0x81, 0x61, 0x61,
+		0x00, 0x00, 0x00, 0x00, // cell overflow: 0
+		0x00, 0x00, 0x00, 0x04, // cell payload size: 4
+		0xa1, 0x01, 0x81, 0x01, // cell payload: {1:[1]}
+	}, w.Bytes())
+
+	q := newPage(64, 24, slottedCells)
+	n, err = q.ReadFrom(&w)
+	assert.NoError(err)
+	assert.Equal(int64(64), n)
+	assert.Equal(leaf, q.pageType)
+	assert.Len(q.cells, 2)
+	assert.Equal(values{uint64(1)}, q.cells[0].Key)
+	assert.Equal(values{uint64(2)}, q.cells[1].Key)
+	assert.Equal(values{"a"}, q.cells[1].Value)
+
+	// the cells take 2+8+4 and 2+8+8 bytes.
+	assert.Equal(pageHeaderSize+14+18, p.used())
+	assert.False(p.willOverflow(&cell{Payload: Payload{Key: values{3}}}))
+	assert.True(p.willOverflow(&cell{Payload: Payload{Key: values{3}, Value: values{"abcdefghij"}}}))
+}
+
+func TestPage_Compressed(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newPage(128, 48, slottedCells)
+	p.keys = compressedKeys
+	p.pageType = leaf
+	for _, k := range []string{"user-0001", "user-0002", "user-0003"} {
+		p.cells = append(p.cells, cell{Payload: Payload{Key: values{k}, Value: values{1}}})
+	}
+
+	var w bytes.Buffer
+	n, err := p.WriteTo(&w)
+	assert.NoError(err)
+	assert.Equal(int64(128), n)
+
+	b := w.Bytes()
+	assert.Equal([]byte{0x00, 0x09}, b[pageHeaderSize:pageHeaderSize+2]) // prefix length: 9
+	assert.Equal(append([]byte{tagString}, "user-000"...), b[pageHeaderSize+2:pageHeaderSize+11])
+
+	q := newPage(128, 48, slottedCells)
+	q.keys = compressedKeys
+	n, err = q.ReadFrom(&w)
+	assert.NoError(err)
+	assert.Equal(int64(128), n)
+	assert.Len(q.cells, 3)
+	for i, k := range []string{"user-0001", "user-0002", "user-0003"} {
+		assert.Equal(values{k}, q.cells[i].Key)
+		assert.Equal(values{uint64(1)}, q.cells[i].Value)
+	}
+
+	// the prefix is stored once instead of 3 times.
+	o := p.clone()
+	o.keys = orderedKeys
+	assert.Equal(o.used()+prefixHeaderSize+9-3*9, p.used())
+
+	// a single cell doesn't share its key.
+	p.cells = p.cells[:1]
+	assert.Nil(p.keyPrefix(p.cells))
+}
+
+func TestPage_Decode(t *testing.T) {
+	// decode decodes the page written by p and overwrites the bytes it's decoded from.
+	decode := func(t *testing.T, p *Page) {
+		assert := assert.New(t)
+
+		var w bytes.Buffer
+		_, err := p.WriteTo(&w)
+		assert.NoError(err)
+		b := w.Bytes()
+		orig := append([]byte{}, b...)
+
+		q := newPage(p.size, p.cellSize, p.format)
+		q.keys = p.keys
+		assert.NoError(q.decode(b))
+
+		// the page doesn't keep the bytes such as a memory mapping which may change after it's decoded.
+		for i := range b {
+			b[i] = 0xff
+		}
+
+		r := newPage(p.size, p.cellSize, p.format)
+		r.keys = p.keys
+		_, err = r.ReadFrom(bytes.NewReader(orig))
+		assert.NoError(err)
+		assert.Equal(r, q)
+	}
+
+	for name, f := range map[string]format{"fixed": fixedCells, "slotted": slottedCells} {
+		f := f
+		t.Run(name, func(t *testing.T) {
+			p := newPage(128, 32, f)
+			p.keys = compressedKeys
+			p.pageType = leaf
+			for _, k := range []string{"a-1", "a-2"} {
+				p.cells = append(p.cells, cell{Payload: Payload{Key: values{k}, Value: values{[]byte(k)}}})
+			}
+			decode(t, p)
+		})
+	}
+
+	t.Run("overflow", func(t *testing.T) {
+		p := newPage(128, 32, slottedCells)
+		p.pageType = overflow
+		p.next = 2
+		p.data = []byte("foo")
+		decode(t, p)
+	})
+}
+
 func TestPage_Insert(t *testing.T) {
 	assert := assert.New(t)
 
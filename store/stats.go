@@ -0,0 +1,151 @@
+package store
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// Stats is the shape of a tree.
+type Stats struct {
+	Depth         int     // number of levels including the leaves
+	BranchPages   int     // number of branch pages
+	LeafPages     int     // number of leaf pages
+	OverflowPages int     // number of overflow pages of the cells
+	Cells         int     // number of cells in the leaves
+	OverflowCells int     // number of cells in the leaves which spill into overflow pages
+	LeafFill      float64 // ratio of the bytes taken by cells to the bytes available for them in the leaves
+	Utilization   float64 // same as LeafFill but of both the leaves and the branches
+	Bytes         int64   // size of all the pages of the tree
+}
+
+// Stats walks the tree rooted at root and returns its shape.
+func (b *BTree) Stats(root int) (*Stats, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.stats(root)
+}
+
+// Utilization returns the ratio of the bytes taken by cells to the bytes available for them in the leaves and branches
+// of the tree rooted at root. see Stats.
+func (b *BTree) Utilization(root int) (float64, error) {
+	s, err := b.Stats(root)
+	if err != nil {
+		return 0, err
+	}
+	return s.Utilization, nil
+}
+
+func (b *BTree) stats(root int) (*Stats, error) {
+	var (
+		s              Stats
+		leafUsed, used int
+	)
+	if err := b.walkStats(&s, pageNo(root), 1, &leafUsed, &used); err != nil {
+		return nil, err
+	}
+	room := int(b.PageSize) - pageHeaderSize
+	s.LeafFill = float64(leafUsed) / float64(s.LeafPages*room)
+	s.Utilization = float64(used) / float64((s.LeafPages+s.BranchPages)*room)
+	s.Bytes = int64(s.BranchPages+s.LeafPages+s.OverflowPages) * int64(b.PageSize)
+	return &s, nil
+}
+
+// walkStats adds the numbers of the page n at the depth and its descendants to s.
+func (b *BTree) walkStats(s *Stats, n pageNo, depth int, leafUsed, used *int) error {
+	p, err := b.get(n)
+	if err != nil {
+		return xerrors.Errorf("failed to get page: %w", err)
+	}
+	if depth > s.Depth {
+		s.Depth = depth
+	}
+	u := p.used() - pageHeaderSize
+	*used += u
+	for i := range p.cells {
+		chain, err := b.overflowChain(p.cells[i].overflow)
+		if err != nil {
+			return err
+		}
+		s.OverflowPages += len(chain)
+		if p.pageType == leaf && len(chain) > 0 {
+			s.OverflowCells++
+		}
+	}
+	switch p.pageType {
+	case leaf:
+		s.LeafPages++
+		s.Cells += len(p.cells)
+		*leafUsed += u
+		return nil
+	case branch:
+		s.BranchPages++
+		for i := -1; i < len(p.cells); i++ {
+			if err := b.walkStats(s, p.childAt(i), depth+1, leafUsed, used); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return xerrors.Errorf("invalid page type: %s", p.pageType)
+	}
+}
+
+// Summary is the statistics of the whole file.
+type Summary struct {
+	Pages     int         // number of pages in the file including the header
+	FreePages int         // number of pages in the free list
+	Catalog   *Stats      // the tree at the header root
+	Trees     []TreeStats // the trees registered in the catalog in the order of their keys
+}
+
+// TreeStats is the shape of a tree registered in the catalog.
+type TreeStats struct {
+	Key []interface{} // key of the catalog entry such as ["table", "foo"]
+	*Stats
+}
+
+// Summary walks the catalog and every tree registered in it.
+func (b *BTree) Summary() (*Summary, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	s := Summary{
+		Pages:     int(b.pages),
+		FreePages: int(b.FreePageCount),
+	}
+	if b.RootPageNo == 0 {
+		return &s, nil
+	}
+
+	c, err := b.stats(b.header.Root())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get stats of catalog: %w", err)
+	}
+	s.Catalog = c
+
+	iter, err := b.first(b.header.Root())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get first: %w", err)
+	}
+	for {
+		if err := iter.next(); err != nil {
+			if err == io.EOF {
+				return &s, nil
+			}
+			return nil, xerrors.Errorf("failed to iterate catalog: %w", err)
+		}
+		if len(iter.Value) == 0 {
+			return nil, xerrors.Errorf("catalog entry %#v has no root", values(iter.Key))
+		}
+		r, ok := iter.Value[0].(uint64)
+		if !ok {
+			return nil, xerrors.Errorf("catalog entry %#v has no root", values(iter.Key))
+		}
+		t, err := b.stats(int(r))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get stats of %#v: %w", values(iter.Key), err)
+		}
+		s.Trees = append(s.Trees, TreeStats{Key: iter.Key, Stats: t})
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBTree_Stats(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	b, err := Create(filepath.Join(dir, "test.db"), PageSize(256), CellSize(32))
+	assert.NoError(err)
+	defer func() { assert.NoError(b.Close()) }()
+
+	s, err := b.Summary()
+	assert.NoError(err)
+	assert.Equal(&Summary{Pages: 1}, s)
+
+	c, err := b.CreateRoot()
+	assert.NoError(err)
+
+	// foo has 1000 small rows and bar has 10 rows which spill into overflow pages.
+	foo, err := b.CreateRoot()
+	assert.NoError(err)
+	for i := 0; i < 1000; i++ {
+		foo, err = b.InsertAppend(foo, values{i}, values{fmt.Sprintf("foo-%d", i)})
+		assert.NoError(err)
+	}
+	bar, err := b.CreateRoot()
+	assert.NoError(err)
+	for i := 0; i < 10; i++ {
+		bar, err = b.Insert(bar, values{i}, values{strings.Repeat("bar", 100)})
+		assert.NoError(err)
+	}
+	c, err = b.Insert(c, values{"table", "foo"}, values{foo, "create table foo"})
+	assert.NoError(err)
+	c, err = b.Insert(c, values{"table", "bar"}, values{bar, "create table bar"})
+	assert.NoError(err)
+	assert.NoError(b.UpdateRoot(c))
+
+	// a dropped tree leaves a free page.
+	baz, err := b.CreateRoot()
+	assert.NoError(err)
+	assert.NoError(b.Drop(baz))
+
+	fs, err := b.Stats(foo)
+	assert.NoError(err)
+	assert.Equal(3, fs.Depth)
+	assert.True(fs.BranchPages > 1)
+	assert.True(fs.LeafPages > fs.BranchPages)
+	assert.Zero(fs.OverflowPages)
+	assert.Equal(1000, fs.Cells)
+	assert.Zero(fs.OverflowCells)
+	assert.True(fs.LeafFill > 0.9, "leaf fill: %f", fs.LeafFill)
+	assert.True(fs.Utilization > 0.8, "utilization: %f", fs.Utilization)
+	assert.Equal(int64(fs.BranchPages+fs.LeafPages)*256, fs.Bytes)
+
+	bs, err := b.Stats(bar)
+	assert.NoError(err)
+	assert.Equal(10, bs.Cells)
+	assert.Equal(10, bs.OverflowCells)
+	assert.Equal(20, bs.OverflowPages) // 300 bytes of a payload don't fit in a cell and a page.
+	assert.Equal(int64(bs.BranchPages+bs.LeafPages+bs.OverflowPages)*256, bs.Bytes)
+
+	s, err = b.Summary()
+	assert.NoError(err)
+	assert.Equal(int(b.pages), s.Pages)
+	assert.Equal(1, s.FreePages)
+	assert.Equal(1, s.Catalog.Depth)
+	assert.Equal(2, s.Catalog.Cells)
+	assert.Equal(2, s.Catalog.OverflowCells) // catalog entries have SQL.
+	assert.Equal([]TreeStats{
+		{Key: []interface{}{"table", "bar"}, Stats: bs},
+		{Key: []interface{}{"table", "foo"}, Stats: fs},
+	}, s.Trees)
+
+	// every page is the header, a free page or a page of the trees.
+	n := 1 + s.FreePages + s.Catalog.LeafPages + s.Catalog.OverflowPages
+	for _, t := range s.Trees {
+		n += t.BranchPages + t.LeafPages + t.OverflowPages
+	}
+	assert.Equal(s.Pages, n)
+}
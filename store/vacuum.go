@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+// vacuumSuffix is appended to the name of the database file to get the name of the file Vacuum writes.
+const vacuumSuffix = "-vacuum"
+
+// Vacuum rewrites the catalog and the trees registered in it into a new file and replaces the file with it. the new
+// file has neither free pages nor half-empty pages since every tree is bulk loaded. the other operations wait until
+// it's done. the storage has to be either a file or Memory.
+func (b *BTree) Vacuum() error {
+	b.lock()
+	defer b.unlock()
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	if err := b.checkpoint(); err != nil {
+		return xerrors.Errorf("failed to checkpoint: %w", err)
+	}
+	switch f := b.file.(type) {
+	case interface{ Name() string }: // a file or its memory mapping
+		return b.vacuumFile(f.Name())
+	case *Memory:
+		return b.vacuumMemory(f)
+	default:
+		return xerrors.Errorf("can't vacuum storage: %T", b.file)
+	}
+}
+
+func (b *BTree) vacuumFile(name string) (err error) {
+	tmp := name + vacuumSuffix
+	d, err := Create(tmp, b.formatOptions()...)
+	if err != nil {
+		return xerrors.Errorf("failed to create %s: %w", tmp, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = d.Close()
+			_ = os.Remove(tmp)
+			_ = os.Remove(tmp + walSuffix)
+		}
+	}()
+
+	if err := b.copyTo(d); err != nil {
+		return err
+	}
+	// closing checkpoints the new file and removes its log.
+	if err := d.Close(); err != nil {
+		return xerrors.Errorf("failed to close %s: %w", tmp, err)
+	}
+
+	// the new file is locked before it takes the name so that no other process gets in between. the ones waiting
+	// for the lock of the old file see it's replaced and open the new one. see checkReplaced.
+	f, err := os.OpenFile(tmp, os.O_RDWR, 0666)
+	if err != nil {
+		return xerrors.Errorf("failed to reopen %s: %w", tmp, err)
+	}
+	if err := lockFile(f, true, b.lockTimeout); err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("failed to lock %s: %w", tmp, err)
+	}
+
+	// the log of the file is empty after the checkpoint so the new file is consistent on its own.
+	if err := os.Rename(tmp, name); err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("failed to replace %s: %w", name, err)
+	}
+	if b.syncPolicy != SyncNone {
+		// make the rename durable. some platforms can't sync directories so it's the best effort.
+		if d, err := os.Open(filepath.Dir(name)); err == nil {
+			_ = d.Sync()
+			_ = d.Close()
+		}
+	}
+	if c, ok := b.file.(io.Closer); ok {
+		_ = c.Close()
+	}
+	b.file = &fileStorage{File: f}
+	if err := b.mapFile(); err != nil {
+		return err
+	}
+	return b.reload(b.file)
+}
+
+// vacuumMemory compacts into a scratch Memory and copies it back so that the caller's Memory has the new image.
+func (b *BTree) vacuumMemory(m *Memory) error {
+	s := &Memory{}
+	d, err := CreateStorage(s, b.formatOptions()...)
+	if err != nil {
+		return err
+	}
+	if err := b.copyTo(d); err != nil {
+		return err
+	}
+	m.replace(s)
+	return b.reload(m)
+}
+
+// formatOptions returns the options to create a file of the same format.
+func (b *BTree) formatOptions() []option {
+	return []option{PageSize(b.PageSize), CellSize(b.CellSize), pageFormat(b.Format), keyEncoding(b.Keys)}
+}
+
+// copyTo copies the catalog and the trees registered in it into d.
+func (b *BTree) copyTo(d *BTree) error {
+	if b.RootPageNo == 0 {
+		return nil
+	}
+	c, err := vacuumCatalog(b, d)
+	if err != nil {
+		return err
+	}
+	if err := d.UpdateRoot(c); err != nil {
+		return xerrors.Errorf("failed to update root: %w", err)
+	}
+	return nil
+}
+
+// reload switches to the storage s and reads it from scratch.
+func (b *BTree) reload(s Storage) error {
+	b.file = s
+	b.cache = newCache(b.cache.size)
+	b.generation++
+	return b.recover()
+}
+
+// vacuumCatalog copies the catalog of s and the trees registered in it into d and returns the new catalog root.
+func vacuumCatalog(s, d *BTree) (int, error) {
+	iter, err := s.first(s.header.Root())
+	if err != nil {
+		return 0, xerrors.Errorf("failed to get first: %w", err)
+	}
+	var entries pairList
+	for {
+		if err := iter.next(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, xerrors.Errorf("failed to iterate catalog: %w", err)
+		}
+		if len(iter.Value) == 0 {
+			return 0, xerrors.Errorf("catalog entry %#v has no root", values(iter.Key))
+		}
+		o, ok := iter.Value[0].(uint64)
+		if !ok {
+			return 0, xerrors.Errorf("catalog entry %#v has no root", values(iter.Key))
+		}
+		t, err := s.first(int(o))
+		if err != nil {
+			return 0, xerrors.Errorf("failed to get first of %#v: %w", values(iter.Key), err)
+		}
+		r, err := d.BulkLoad(context.Background(), &iteratorPairs{iter: t}, 1)
+		if err != nil {
+			return 0, xerrors.Errorf("failed to copy %#v: %w", values(iter.Key), err)
+		}
+		v := append([]interface{}{uint64(r)}, iter.Value[1:]...)
+		entries = append(entries, [2][]interface{}{iter.Key, v})
+	}
+	c, err := d.BulkLoad(context.Background(), &entries, 1)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to copy catalog: %w", err)
+	}
+	return c, nil
+}
+
+// iteratorPairs reads the pairs of a tree for BulkLoad without locking it.
+type iteratorPairs struct {
+	iter *Iterator
+}
+
+func (i *iteratorPairs) Next() ([]interface{}, []interface{}, error) {
+	if err := i.iter.next(); err != nil {
+		return nil, nil, err
+	}
+	return i.iter.Key, i.iter.Value, nil
+}
+
+// pairList is a list of pairs sorted by keys.
+type pairList [][2][]interface{}
+
+func (p *pairList) Next() ([]interface{}, []interface{}, error) {
+	if len(*p) == 0 {
+		return nil, nil, io.EOF
+	}
+	kv := (*p)[0]
+	*p = (*p)[1:]
+	return kv[0], kv[1], nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBTree_Vacuum(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(err)
+	defer func() { assert.NoError(os.RemoveAll(dir)) }()
+
+	name := filepath.Join(dir, "test.db")
+	b, err := Create(name, PageSize(256), CellSize(32))
+	assert.NoError(err)
+
+	// two tables of random inserts and deletes leave half-empty pages and free pages behind.
+	c, err := b.CreateRoot()
+	assert.NoError(err)
+	rnd := rand.New(rand.NewSource(1))
+	for _, table := range []string{"foo", "bar"} {
+		r, err := b.CreateRoot()
+		assert.NoError(err)
+		for _, i := range rnd.Perm(1000) {
+			r, err = b.Insert(r, values{i}, values{fmt.Sprintf("%s-%d", table, i)})
+			assert.NoError(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if i%3 == 0 {
+				continue
+			}
+			r, err = b.Delete(r, values{i})
+			assert.NoError(err)
+		}
+		c, err = b.Insert(c, values{"table", table}, values{r, "create table " + table})
+		assert.NoError(err)
+	}
+	assert.NoError(b.UpdateRoot(c))
+	assert.NotZero(b.FreePageCount)
+	pages := b.pages
+
+	// tables of the catalog.
+	tables := func(b *BTree) map[string][]interface{} {
+		ts := map[string][]interface{}{}
+		iter, err := b.First(b.Root())
+		assert.NoError(err)
+		for iter.Next() == nil {
+			t, err := b.First(int(iter.Value[0].(uint64)))
+			assert.NoError(err)
+			var rows []interface{}
+			for t.Next() == nil {
+				rows = append(rows, t.Key[0], t.Value[0])
+			}
+			ts[iter.Key[1].(string)] = rows
+		}
+		return ts
+	}
+	before := tables(b)
+
+	// the iterators over the old pages stop instead of following their links into the new file.
+	stale, err := b.First(b.Root())
+	assert.NoError(err)
+	assert.NoError(stale.Next())
+
+	assert.NoError(b.Vacuum())
+	assert.Equal(ErrStaleIterator, stale.Next())
+	assert.Equal(ErrStaleIterator, stale.Prev())
+	assert.Zero(b.FreePageCount)
+	assert.True(b.pages < pages/2, "%d >= %d", b.pages, pages/2)
+	assert.Equal(before, tables(b))
+	report, err := b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+
+	_, err = os.Stat(name + vacuumSuffix)
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(name + vacuumSuffix + walSuffix)
+	assert.True(os.IsNotExist(err))
+
+	// the tree keeps working on the new file.
+	vs, err := b.Search(b.Root(), values{"table", "foo"})
+	assert.NoError(err)
+	r, err := b.Insert(int(vs[0].(uint64)), values{1}, values{"foo-1"})
+	assert.NoError(err)
+	_, err = b.Update(b.Root(), values{"table", "foo"}, values{r, vs[1]})
+	assert.NoError(err)
+	assert.NoError(b.Close())
+
+	b, err = Open(name)
+	assert.NoError(err)
+	defer func() { assert.NoError(b.Close()) }()
+	after := tables(b)
+	assert.Equal(append([]interface{}{uint64(0), "foo-0", uint64(1), "foo-1"}, before["foo"][2:]...), after["foo"])
+	assert.Equal(before["bar"], after["bar"])
+	report, err = b.Check()
+	assert.NoError(err)
+	assert.Empty(report.Problems)
+
+	iter, err := b.First(b.Root())
+	assert.NoError(err)
+	assert.NoError(iter.Next())
+	assert.NoError(iter.Next())
+	assert.Equal(io.EOF, iter.Next())
+}
@@ -1,88 +1,120 @@
 package store
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 
-	"github.com/pkg/errors"
 	"github.com/ugorji/go/codec"
 )
 
+// values is a key or a value of a cell. an element is nil, bool, an integer, float64, string, []byte or time.Time.
+// integers are decoded into int64 if negative or uint64 otherwise. times are decoded in UTC with nanoseconds.
 type values []interface{}
 
 var handle codec.CborHandle
 
-var errNotComparable = errors.New("not comparable")
+// extendedTimeTag is the CBOR tag of extended time https://www.iana.org/assignments/cbor-tags/cbor-tags.xhtml
+const extendedTimeTag = 1001
 
-func (v values) compare(o values) int {
-	if len(v) != len(o) {
-		panic(errNotComparable)
+// extendedTime is a time in CBOR which keeps nanoseconds unlike the built-in epoch time. the zero time is kept too
+// instead of being encoded as nil.
+type extendedTime struct {
+	_struct bool  `codec:",int"`
+	Sec     int64 `codec:"1"`
+	Nsec    int64 `codec:"-9"`
+}
+
+func init() {
+	if err := handle.SetInterfaceExt(reflect.TypeOf(extendedTime{}), extendedTimeTag, codec.SelfExt); err != nil {
+		panic(err)
+	}
+}
+
+// CodecEncodeSelf encodes the times in v as extended times.
+func (v values) CodecEncodeSelf(e *codec.Encoder) {
+	if v == nil {
+		e.MustEncode(nil)
+		return
+	}
+	vs := make([]interface{}, len(v))
+	for i, e := range v {
+		if t, ok := e.(time.Time); ok {
+			e = extendedTime{Sec: t.Unix(), Nsec: int64(t.Nanosecond())}
+		}
+		vs[i] = e
+	}
+	e.MustEncode(vs)
+}
+
+// CodecDecodeSelf decodes the extended times as well as the epoch times written before into time.Time.
+func (v *values) CodecDecodeSelf(d *codec.Decoder) {
+	vs := []interface{}(*v) // the elements are decoded into the types of the ones already in v if any.
+	d.MustDecode(&vs)
+	for i, t := range vs {
+		if t, ok := t.(extendedTime); ok {
+			vs[i] = time.Unix(t.Sec, t.Nsec).UTC()
+		}
+	}
+	*v = vs
+}
+
+// ErrNotComparable is returned when a key contains a value of a type which can't be ordered.
+type ErrNotComparable struct {
+	Value interface{}
+}
+
+func (e *ErrNotComparable) Error() string {
+	return fmt.Sprintf("not comparable: %T", e.Value)
+}
+
+// compare returns -1, 0 or 1 if v is less than, equal to or greater than o respectively.
+// they're compared in the order of encoded keys.
+func (v values) compare(o values) (int, error) {
+	k, err := encodeKey(v)
+	if err != nil {
+		return 0, err
+	}
+	l, err := encodeKey(o)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Compare(k, l), nil
+}
+
+// search is sort.Search with a predicate which may fail. it returns the first error from the predicate.
+func search(n int, f func(int) (bool, error)) (int, error) {
+	var err error
+	i := sort.Search(n, func(i int) bool {
+		if err != nil {
+			return false
+		}
+		ok, e := f(i)
+		if e != nil {
+			err = e
+			return false
+		}
+		return ok
+	})
+	return i, err
+}
+
+// clone returns a copy of v which shares no memory with v.
+func (v values) clone() values {
+	if v == nil {
+		return nil
 	}
-	for i := range v {
-		switch v := v[i].(type) {
-		case int:
-			var w int
-			switch o := o[i].(type) {
-			case int:
-				w = o
-			case int64:
-				w = int(o)
-			case uint64:
-				w = int(o)
-			default:
-				panic(fmt.Errorf("not comparable: index=%d, left=%T, right=%T", i, v, o))
-			}
-			if d := v - w; d != 0 {
-				return d
-			}
-		case int64:
-			var w int64
-			switch o := o[i].(type) {
-			case int:
-				w = int64(o)
-			case int64:
-				w = o
-			case uint64:
-				w = int64(o)
-			default:
-				panic(fmt.Errorf("not comparable: index=%d, left=%T, right=%T", i, v, o))
-			}
-			if d := v - w; d != 0 {
-				return int(d)
-			}
-		case uint64:
-			var w uint64
-			switch o := o[i].(type) {
-			case int:
-				w = uint64(o)
-			case int64:
-				w = uint64(o)
-			case uint64:
-				w = o
-			default:
-				panic(fmt.Errorf("not comparable: index=%d, left=%T, right=%T", i, v, o))
-			}
-			if d := v - w; d != 0 {
-				return int(d)
-			}
-		case string:
-			w, ok := o[i].(string)
-			if !ok {
-				panic(fmt.Errorf("not comparable: index=%d, left=%T, right=%T", i, v, o[i]))
-			}
-			switch {
-			case v < w:
-				return -1
-			case v == w:
-				return 0
-			case v > w:
-				return 1
-			}
-		default:
-			panic(fmt.Errorf("not comparable: index=%d, left=%T, right=%T", i, v, o[i]))
+	c := make(values, len(v))
+	for i, e := range v {
+		if b, ok := e.([]byte); ok {
+			e = append([]byte{}, b...)
 		}
+		c[i] = e
 	}
-	return 0
+	return c
 }
 
 func (v values) GoString() string {
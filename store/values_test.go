@@ -1,28 +1,111 @@
 package store
 
 import (
+	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
 )
 
 func TestValues_Compare(t *testing.T) {
+	compare := func(v, o values) int {
+		d, err := v.compare(o)
+		assert.NoError(t, err)
+		return d
+	}
+
 	t.Run("less", func(t *testing.T) {
 		assert := assert.New(t)
-		assert.Equal(-1, values{1}.compare(values{2}))
-		assert.Equal(-1, values{1, 2}.compare(values{1, 3}))
+		assert.Equal(-1, compare(values{1}, values{2}))
+		assert.Equal(-1, compare(values{1, 2}, values{1, 3}))
+		assert.Equal(-1, compare(values{"x", 1}, values{"x", 2}))
+		assert.Equal(-1, compare(values{-1}, values{uint64(math.MaxUint64)}))
+		assert.Equal(-1, compare(values{uint64(0)}, values{uint64(math.MaxUint64)}))
 	})
 
 	t.Run("equal", func(t *testing.T) {
 		assert := assert.New(t)
-		assert.Equal(0, values{1}.compare(values{1}))
-		assert.Equal(0, values{1, 2}.compare(values{1, 2}))
-		assert.Equal(0, values{"x"}.compare(values{"x"}))
+		assert.Equal(0, compare(values{1}, values{1}))
+		assert.Equal(0, compare(values{1, 2}, values{1, 2}))
+		assert.Equal(0, compare(values{"x"}, values{"x"}))
+		assert.Equal(0, compare(values{1}, values{uint64(1)}))
 	})
 
 	t.Run("greater", func(t *testing.T) {
 		assert := assert.New(t)
-		assert.Equal(1, values{2}.compare(values{1}))
-		assert.Equal(1, values{1, 3}.compare(values{1, 2}))
+		assert.Equal(1, compare(values{2}, values{1}))
+		assert.Equal(1, compare(values{1, 3}, values{1, 2}))
+		assert.Equal(1, compare(values{"x", 2}, values{"x", 1}))
+		assert.Equal(1, compare(values{uint64(math.MaxUint64)}, values{int64(math.MinInt64)}))
+	})
+
+	t.Run("prefix", func(t *testing.T) {
+		assert := assert.New(t)
+		assert.Equal(-1, compare(values{1}, values{1, 2}))
+		assert.Equal(-1, compare(values{"x"}, values{"x", ""}))
+		assert.Equal(1, compare(values{"xy"}, values{"x", "y"}))
+	})
+
+	t.Run("types", func(t *testing.T) {
+		assert := assert.New(t)
+		assert.Equal(-1, compare(values{nil}, values{-1}))
+		assert.Equal(-1, compare(values{-1}, values{0}))
+		assert.Equal(-1, compare(values{uint64(math.MaxUint64)}, values{-1.5}))
+		assert.Equal(-1, compare(values{1.5}, values{""}))
+		assert.Equal(-1, compare(values{"x"}, values{[]byte("x")}))
+		assert.Equal(-1, compare(values{[]byte("x")}, values{false}))
+		assert.Equal(-1, compare(values{true}, values{time.Time{}}))
+	})
+
+	t.Run("not comparable", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := values{1, float32(1)}.compare(values{1, 2})
+		assert.Equal(&ErrNotComparable{Value: float32(1)}, err)
+
+		_, err = values{1}.compare(values{struct{}{}})
+		assert.Equal(&ErrNotComparable{Value: struct{}{}}, err)
+	})
+}
+
+func TestValues_Codec(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		assert := assert.New(t)
+
+		tz := time.FixedZone("JST", 9*60*60)
+		v := values{
+			nil, true, uint64(1), int64(-1), 1.5, "foo", []byte("bar"),
+			time.Time{},
+			time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+			time.Date(1900, 1, 2, 3, 4, 5, 999999999, tz),
+		}
+		c := cell{Payload: Payload{Key: v, Value: v}}
+		raw, err := c.encode(cborKeys, nil)
+		assert.NoError(err)
+
+		d := cell{raw: raw, length: uint32(len(raw))}
+		assert.NoError(d.decode())
+		for _, o := range []values{d.Key, d.Value} {
+			assert.Len(o, len(v))
+			assert.Equal(v[:8], o[:8])
+			assert.Equal(v[8], o[8])
+			assert.True(v[9].(time.Time).Equal(o[9].(time.Time)))
+			assert.Equal(time.UTC, o[9].(time.Time).Location())
+		}
+	})
+
+	t.Run("epoch time", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// times were written as epoch times in microseconds before.
+		var h codec.CborHandle
+		var raw []byte
+		assert.NoError(codec.NewEncoderBytes(&raw, &h).Encode([]interface{}{time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)}))
+
+		var v values
+		assert.NoError(codec.NewDecoderBytes(raw, &handle).Decode(&v))
+		assert.Equal(values{time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)}, v)
 	})
 }
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"golang.org/x/xerrors"
+)
+
+// walSuffix is appended to the name of the database file to get the name of its write-ahead log.
+const walSuffix = "-wal"
+
+// walCheckpointFrames is the number of frames which triggers a checkpoint.
+const walCheckpointFrames = 1000
+
+const frameHeaderSize = 4 + 4 + 4 // page no + flags + checksum
+
+const frameCommit uint32 = 1 << 0
+
+// wal is a write-ahead log. images of pages are appended to it and synced before they're written to the database file
+// so that a batch of pages can be replayed after a crash.
+type wal struct {
+	file   *os.File
+	size   int64
+	frames int
+	noSync bool // append doesn't sync the log. see SyncNone.
+}
+
+type frame struct {
+	pageNo pageNo
+	data   []byte
+}
+
+func openWAL(name string) (*wal, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{file: f}, nil
+}
+
+// append writes the frames as a batch and syncs the log unless noSync. the last frame is marked as commit.
+func (w *wal) append(fs []frame) error {
+	var buf bytes.Buffer
+	for i, f := range fs {
+		var flags uint32
+		if i == len(fs)-1 {
+			flags |= frameCommit
+		}
+		h := make([]byte, frameHeaderSize)
+		binary.BigEndian.PutUint32(h[0:], uint32(f.pageNo))
+		binary.BigEndian.PutUint32(h[4:], flags)
+		binary.BigEndian.PutUint32(h[8:], checksum(h[:8], f.data))
+		buf.Write(h)
+		buf.Write(f.data)
+	}
+	if _, err := w.file.WriteAt(buf.Bytes(), w.size); err != nil {
+		return w.truncate(xerrors.Errorf("failed to write frames: %w", err))
+	}
+	if !w.noSync {
+		if err := w.file.Sync(); err != nil {
+			return w.truncate(xerrors.Errorf("failed to sync frames: %w", err))
+		}
+	}
+	w.size += int64(buf.Len())
+	w.frames += len(fs)
+	return nil
+}
+
+// truncate cuts the frames of the failed batch off the log so that they aren't replayed. if it can't, they may be
+// replayed and ErrBroken is returned.
+func (w *wal) truncate(err error) error {
+	if e := w.file.Truncate(w.size); e != nil {
+		return xerrors.Errorf("%v: %w", err, ErrBroken)
+	}
+	if w.noSync {
+		return err
+	}
+	if e := w.file.Sync(); e != nil {
+		return xerrors.Errorf("%v: %w", err, ErrBroken)
+	}
+	return err
+}
+
+// recover returns the frames of committed batches. an incomplete or broken batch at the end is ignored.
+func (w *wal) recover(pageSize int) []frame {
+	r := io.NewSectionReader(w.file, 0, 1<<62)
+	var committed, pending []frame
+	for {
+		h := make([]byte, frameHeaderSize)
+		if _, err := io.ReadFull(r, h); err != nil {
+			break
+		}
+		f := frame{
+			pageNo: pageNo(binary.BigEndian.Uint32(h[0:])),
+			data:   make([]byte, pageSize),
+		}
+		if _, err := io.ReadFull(r, f.data); err != nil {
+			break
+		}
+		if binary.BigEndian.Uint32(h[8:]) != checksum(h[:8], f.data) {
+			break
+		}
+		pending = append(pending, f)
+		if binary.BigEndian.Uint32(h[4:])&frameCommit != 0 {
+			committed = append(committed, pending...)
+			pending = pending[:0]
+		}
+	}
+	return committed
+}
+
+// reset discards all the frames.
+func (w *wal) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	w.frames = 0
+	return w.file.Sync()
+}
+
+func (w *wal) close() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(w.file.Name())
+}
+
+func checksum(bs ...[]byte) uint32 {
+	h := crc32.NewIEEE()
+	for _, b := range bs {
+		_, _ = h.Write(b)
+	}
+	return h.Sum32()
+}
+
+// replayWAL lays the committed pages in the write-ahead log of the name over the file without writing them to either.
+// it's how a read-only open sees the commits which haven't been checkpointed.
+func (b *BTree) replayWAL(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to open log: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	fs := (&wal{file: f}).recover(int(b.PageSize))
+	if len(fs) == 0 {
+		return nil
+	}
+	size, err := b.file.Size()
+	if err != nil {
+		return xerrors.Errorf("failed to get size: %w", err)
+	}
+	r := replayed{
+		Storage:  b.file,
+		pageSize: int64(b.PageSize),
+		pages:    map[pageNo][]byte{},
+		size:     size,
+	}
+	for _, f := range fs {
+		r.pages[f.pageNo] = f.data
+		if end := b.offset(f.pageNo) + r.pageSize; end > r.size {
+			r.size = end
+		}
+	}
+	b.file = &r
+	return nil
+}
+
+// replayed is a storage which reads the images of pages in place of the pages of the underlying storage.
+type replayed struct {
+	Storage
+	pageSize int64
+	pages    map[pageNo][]byte
+	size     int64
+}
+
+func (r *replayed) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.Storage.ReadAt(p, off); err != nil && err != io.EOF {
+		return 0, err
+	}
+	end := off + int64(len(p))
+	for n := off / r.pageSize; n*r.pageSize < end; n++ {
+		data, ok := r.pages[pageNo(n)]
+		if !ok {
+			continue
+		}
+		start := n * r.pageSize
+		if start >= off {
+			copy(p[start-off:], data)
+		} else {
+			copy(p, data[off-start:])
+		}
+	}
+	if end > r.size {
+		if off >= r.size {
+			return 0, io.EOF
+		}
+		return int(r.size - off), io.EOF
+	}
+	return len(p), nil
+}
+
+func (r *replayed) Size() (int64, error) {
+	return r.size, nil
+}
+
+func (r *replayed) Close() error {
+	if c, ok := r.Storage.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
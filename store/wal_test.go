@@ -0,0 +1,80 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAL_Recover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, os.RemoveAll(dir)) }()
+
+	w, err := openWAL(filepath.Join(dir, "test.db-wal"))
+	assert.NoError(t, err)
+
+	page := func(b byte) []byte {
+		data := make([]byte, 16)
+		for i := range data {
+			data[i] = b
+		}
+		return data
+	}
+
+	assert.NoError(t, w.append([]frame{
+		{pageNo: 1, data: page(1)},
+		{pageNo: 0, data: page(0)},
+	}))
+	assert.NoError(t, w.append([]frame{
+		{pageNo: 2, data: page(2)},
+	}))
+	assert.Equal(t, 3, w.frames)
+
+	t.Run("committed", func(t *testing.T) {
+		assert := assert.New(t)
+
+		fs := w.recover(16)
+		assert.Equal([]frame{
+			{pageNo: 1, data: page(1)},
+			{pageNo: 0, data: page(0)},
+			{pageNo: 2, data: page(2)},
+		}, fs)
+	})
+
+	t.Run("torn", func(t *testing.T) {
+		assert := assert.New(t)
+
+		_, err := w.file.WriteAt([]byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x01}, w.size)
+		assert.NoError(err)
+
+		fs := w.recover(16)
+		assert.Len(fs, 3)
+	})
+
+	t.Run("broken", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// flip a byte in the last frame.
+		_, err := w.file.WriteAt([]byte{0xff}, w.size-1)
+		assert.NoError(err)
+
+		fs := w.recover(16)
+		assert.Len(fs, 2)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		assert := assert.New(t)
+
+		assert.NoError(w.reset())
+		assert.Empty(w.recover(16))
+		assert.Equal(0, w.frames)
+	})
+
+	assert.NoError(t, w.close())
+	_, err = os.Stat(filepath.Join(dir, "test.db-wal"))
+	assert.True(t, os.IsNotExist(err))
+}